@@ -1,25 +1,46 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/marcosQuesada/swarm/internal/k8"
 	"github.com/marcosQuesada/swarm/internal/k8/operator"
 	"github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
 	clientset "github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned"
 	informers "github.com/marcosQuesada/swarm/pkg/generated/informers/externalversions"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
+const (
+	controllerLeaseName = "swarm-controller"
+	// membershipAgentName records the membership handler events
+	membershipAgentName = "swarm-membership"
+)
+
+var (
+	reconcileTimeout    time.Duration
+	shutdownGracePeriod time.Duration
+	leaderElect         bool
+	leaderElectNS       string
+	metricsAddr         string
+	poolKind            string
+	membershipStore     string
+	membershipFile      string
+)
+
 // controllerCmd represents the controller command
 var controllerCmd = &cobra.Command{
 	Use:   "controller",
@@ -57,26 +78,94 @@ to quickly create a Cobra application.`,
 
 		kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Minute*10)
 		swarmInformerFactory := informers.NewSharedInformerFactory(swarmClient, time.Minute*10)
+		// swarm owned objects other than pods are watched through their swarm label
+		labeledInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, time.Minute*10, kubeinformers.WithTweakListOptions(operator.SwarmLabeled))
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer cancel()
+
+		go serveMetrics(ctx, metricsAddr)
 
-		podInformer := kubeInformerFactory.Core().V1().Pods()
+		podInformer := operator.NewSwarmPodInformer(ctx, kubeInformerFactory)
 		swarmInformer := swarmInformerFactory.K8slab().V1alpha1().Swarms()
-		controller := operator.NewController(kubeClient, swarmClient, podInformer, swarmInformer)
+		pvcInformer := labeledInformerFactory.Core().V1().PersistentVolumeClaims()
+		pdbInformer := labeledInformerFactory.Policy().V1().PodDisruptionBudgets()
+		nodeInformer := kubeInformerFactory.Core().V1().Nodes()
+		swarmPeerInformer := swarmInformerFactory.K8slab().V1alpha1().SwarmPeers()
+		serviceInformer := labeledInformerFactory.Core().V1().Services()
+		configMapInformer := labeledInformerFactory.Core().V1().ConfigMaps()
+		var pool operator.Pool
+		switch poolKind {
+		case "http":
+			pool = operator.NewHTTPPool(podInformer.Lister())
+		case "log":
+			pool = operator.NewPool()
+		default:
+			log.Fatalf("unknown pool %q, expected http or log", poolKind)
+		}
+		var store operator.MembershipStore
+		switch membershipStore {
+		case "configmap":
+			store = operator.NewConfigMapMembershipStore(kubeClient)
+		case "bolt":
+			bolt, err := operator.NewBoltMembershipStore(membershipFile)
+			if err != nil {
+				log.Fatalf("opening membership file %s: %v", membershipFile, err)
+			}
+			defer bolt.Close()
+			store = bolt
+		case "none":
+		default:
+			log.Fatalf("unknown membership store %q, expected configmap, bolt or none", membershipStore)
+		}
+		if store != nil {
+			pool = operator.NewDurablePool(pool, store)
+		}
+		controller := operator.NewController(kubeClient, swarmClient, podInformer, swarmInformer, pvcInformer, pdbInformer, nodeInformer, swarmPeerInformer, serviceInformer, configMapInformer, pool, reconcileTimeout)
+		// the membership handler registers spec peers on the pool, each call
+		// bounded by the reconcile timeout. Its last swarm states are kept
+		// along the membership, so a restart only applies later changes.
+		recorder := operator.NewEventRecorder(kubeClient, membershipAgentName)
+		handler := operator.NewHandler(pool, recorder)
+		if store != nil {
+			handler = operator.NewDurableHandler(pool, recorder, store)
+		}
+		membership := operator.Build(handler, &v1alpha1.Swarm{}, operator.NewAdapter(ctx, swarmClient.K8slabV1alpha1()), reconcileTimeout, operator.SwarmUpdatePredicate())
 
 		// notice that there is no need to run Start methods in a separate goroutine. (i.e. go kubeInformerFactory.Start(stopCh))
 		// Start method is non-blocking and runs all registered informers in a dedicated goroutine.
-		kubeInformerFactory.Start(wait.NeverStop)
-		swarmInformerFactory.Start(wait.NeverStop)
-
-		stopCh := make(chan struct{})
-		go func() {
-			sigTerm := make(chan os.Signal, 1)
-			signal.Notify(sigTerm, syscall.SIGTERM, syscall.SIGINT)
-			<-sigTerm
-			close(stopCh)
-		}()
-
-		if err = controller.Run(2, stopCh); err != nil {
-			klog.Fatalf("Error running controller: %s", err.Error())
+		kubeInformerFactory.Start(ctx.Done())
+		swarmInformerFactory.Start(ctx.Done())
+		labeledInformerFactory.Start(ctx.Done())
+
+		var runErr error
+		run := func(ctx context.Context) {
+			// both drain on shutdown before the leader lease is released
+			var handlers sync.WaitGroup
+			handlers.Add(1)
+			go func() {
+				defer handlers.Done()
+				membership.Run(ctx, shutdownGracePeriod)
+			}()
+
+			if runErr = controller.Run(ctx, 2, shutdownGracePeriod); runErr != nil {
+				cancel()
+			}
+			handlers.Wait()
+		}
+
+		if !leaderElect {
+			run(ctx)
+		} else {
+			id, err := os.Hostname()
+			if err != nil {
+				log.Fatalf("unable to get hostname %v", err)
+			}
+			k8.RunWithLeaderElection(ctx, kubeClient, leaderElectNS, controllerLeaseName, id, run)
+		}
+
+		if runErr != nil {
+			klog.Fatalf("Error running controller: %s", runErr.Error())
 		}
 
 	},
@@ -84,4 +173,29 @@ to quickly create a Cobra application.`,
 
 func init() {
 	rootCmd.AddCommand(controllerCmd)
+
+	controllerCmd.Flags().DurationVar(&reconcileTimeout, "reconcile-timeout", time.Second*30, "deadline applied to each swarm reconcile")
+	controllerCmd.Flags().DurationVar(&shutdownGracePeriod, "shutdown-grace-period", time.Second*30, "time given to in-flight reconciles to complete on shutdown")
+	controllerCmd.Flags().BoolVar(&leaderElect, "leader-elect", false, "run only while holding the controller leader lease")
+	controllerCmd.Flags().StringVar(&leaderElectNS, "leader-elect-namespace", "default", "namespace of the controller leader lease")
+	controllerCmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":9090", "address the prometheus metrics endpoint binds to")
+	controllerCmd.Flags().StringVar(&poolKind, "pool", "http", "membership pool, http changes it on the swarm leader admin API, log only logs changes")
+	controllerCmd.Flags().StringVar(&membershipStore, "membership-store", "configmap", "where applied pool membership survives restarts, configmap, bolt file or none")
+	controllerCmd.Flags().StringVar(&membershipFile, "membership-file", "swarm-membership.db", "bolt file of the bolt membership store")
+}
+
+// serveMetrics exposes prometheus metrics on addr until ctx is cancelled
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Errorf("metrics server error %v", err)
+	}
 }
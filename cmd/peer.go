@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	peerRaftPort  int
+	peerAdminPort int
+	peerDataDir   string
+)
+
+// peerCmd runs a swarm member
+var peerCmd = &cobra.Command{
+	Use:   "peer",
+	Short: "Run a swarm peer, a replicated key value raft node",
+	Long: `Run a swarm peer, a replicated key value store member on raft.
+
+The peer identity and the initial voting members are read from the
+environment set by the controller: SWARM_PEER_ID, SWARM_PEER_ADDRESS and
+SWARM_PEERS as comma separated id=host entries. The admin API serves
+status, join, leave and leadership transfer next to the key value API.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := peer.Config{
+			RaftPort:  peerRaftPort,
+			AdminPort: peerAdminPort,
+			DataDir:   peerDataDir,
+		}
+		if err := peer.ConfigFromEnv(&config); err != nil {
+			log.Fatalf("peer configuration: %v", err)
+		}
+
+		node, err := peer.NewNode(config)
+		if err != nil {
+			log.Fatalf("starting peer %s: %v", config.ID, err)
+		}
+		log.Infof("peer %s started on %s, raft port %d, %d initial members", config.ID, config.Address, config.RaftPort, len(config.Peers))
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+		defer cancel()
+
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", peerAdminPort), Handler: peer.NewAPI(node)}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, done := context.WithTimeout(context.Background(), time.Second*5)
+			defer done()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("admin server error %v", err)
+		}
+
+		if err := node.Shutdown(); err != nil {
+			log.Errorf("peer shutdown error %v", err)
+		}
+		log.Infof("peer %s stopped", config.ID)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(peerCmd)
+
+	peerCmd.Flags().IntVar(&peerRaftPort, "raft-port", 7000, "port raft traffic binds to, the same on every peer")
+	peerCmd.Flags().IntVar(&peerAdminPort, "admin-port", 8080, "port the admin and key value API binds to, the same on every peer")
+	peerCmd.Flags().StringVar(&peerDataDir, "data-dir", "/var/lib/swarm/raft", "directory holding the raft log and snapshots")
+}
@@ -0,0 +1,64 @@
+package k8
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// RunWithLeaderElection blocks until ctx is cancelled, running run only while
+// holding the namespace/name lease. The lease is released once run returns,
+// so a standby operator takes over as soon as shutdown completed instead of
+// waiting for the lease to expire.
+func RunWithLeaderElection(ctx context.Context, client kubernetes.Interface, namespace, name, id string, run func(ctx context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Client: client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	}
+
+	// the election runs on its own context, cancelling it releases the lease
+	electionCtx, release := context.WithCancel(context.Background())
+	defer release()
+
+	var leading int32
+	go func() {
+		<-ctx.Done()
+		if atomic.LoadInt32(&leading) == 0 {
+			release()
+		}
+	}()
+
+	leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   time.Second * 15,
+		RenewDeadline:   time.Second * 10,
+		RetryPeriod:     time.Second * 2,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ context.Context) {
+				atomic.StoreInt32(&leading, 1)
+				log.Infof("%s acquired leader lease %s/%s", id, namespace, name)
+				run(ctx)
+				release()
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() == nil {
+					log.Fatalf("%s lost leader lease %s/%s", id, namespace, name)
+				}
+				log.Infof("%s released leader lease %s/%s", id, namespace, name)
+			},
+		},
+	})
+}
@@ -10,17 +10,20 @@ import (
 )
 
 type adapter struct {
+	ctx    context.Context
 	client swarmv1alpha1.K8slabV1alpha1Interface
 }
 
-func NewAdapter(c swarmv1alpha1.K8slabV1alpha1Interface) ListWatcher {
-	return &adapter{client: c}
+// NewAdapter builds a ListWatcher over swarms, ctx cancellation aborts
+// in-flight list and watch calls.
+func NewAdapter(ctx context.Context, c swarmv1alpha1.K8slabV1alpha1Interface) ListWatcher {
+	return &adapter{ctx: ctx, client: c}
 }
 
 func (a *adapter) List(options metav1.ListOptions) (runtime.Object, error) {
-	return a.client.Swarms(api.NamespaceDefault).List(context.Background(), options)
+	return a.client.Swarms(api.NamespaceDefault).List(a.ctx, options)
 }
 
 func (a *adapter) Watch(options metav1.ListOptions) (watch.Interface, error) {
-	return a.client.Swarms(api.NamespaceDefault).Watch(context.Background(), options)
+	return a.client.Swarms(api.NamespaceDefault).Watch(a.ctx, options)
 }
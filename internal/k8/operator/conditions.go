@@ -0,0 +1,76 @@
+package operator
+
+import (
+	"fmt"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// updateScalingStatus raises the Scaling condition while the owned peers
+// differ from Spec.Replicas, recording when scaling starts and completes.
+func (c *Controller) updateScalingStatus(key string, sw *swarmv1alpha1.Swarm) {
+	desired := int32(sw.Spec.Replicas)
+	scaling := meta.IsStatusConditionTrue(sw.Status.Conditions, swarmv1alpha1.ConditionScaling)
+
+	condition := metav1.Condition{
+		Type:               swarmv1alpha1.ConditionScaling,
+		Status:             metav1.ConditionFalse,
+		Reason:             ReasonScalingCompleted,
+		Message:            fmt.Sprintf("Running %d peers", desired),
+		ObservedGeneration: sw.Generation,
+	}
+	switch {
+	case sw.Status.Replicas != desired:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonScalingStarted
+		condition.Message = fmt.Sprintf("Scaling to %d peers", desired)
+		if !scaling {
+			klog.Infof("instance %s: scaling from %d to %d peers", key, sw.Status.Replicas, desired)
+			c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonScalingStarted, "Scaling from %d to %d peers", sw.Status.Replicas, desired)
+		}
+	case scaling:
+		klog.Infof("instance %s: scaled to %d peers", key, desired)
+		c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonScalingCompleted, "Scaled to %d peers", desired)
+	}
+	meta.SetStatusCondition(&sw.Status.Conditions, condition)
+}
+
+// updateQuorumStatus raises the QuorumLost condition once healthy voting
+// peers drop below the quorum the swarm held, a swarm still bootstrapping
+// never held it.
+func (c *Controller) updateQuorumStatus(key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) {
+	healthy := 0
+	for _, pod := range pods {
+		if votingRole(pod.Labels[peerRoleLabel]) && pod.DeletionTimestamp == nil && unhealthySince(pod).IsZero() {
+			healthy++
+		}
+	}
+	required := quorum(votingSize(sw))
+
+	held := meta.FindStatusCondition(sw.Status.Conditions, swarmv1alpha1.ConditionQuorumLost)
+	condition := metav1.Condition{
+		Type:               swarmv1alpha1.ConditionQuorumLost,
+		Status:             metav1.ConditionFalse,
+		Reason:             "QuorumHeld",
+		Message:            fmt.Sprintf("%d healthy voting peers, quorum requires %d", healthy, required),
+		ObservedGeneration: sw.Generation,
+	}
+	if healthy < required {
+		if held == nil {
+			return
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ReasonQuorumLost
+		if held.Status != metav1.ConditionTrue {
+			klog.Warningf("instance %s: quorum lost, %d healthy voting peers of %d required", key, healthy, required)
+			c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonQuorumLost, "%d healthy voting peers, quorum requires %d", healthy, required)
+		}
+	} else if held != nil && held.Status == metav1.ConditionTrue {
+		klog.Infof("instance %s: quorum restored with %d healthy voting peers", key, healthy)
+	}
+	meta.SetStatusCondition(&sw.Status.Conditions, condition)
+}
@@ -0,0 +1,99 @@
+package operator
+
+import (
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScalingEvents(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	pods := []*corev1.Pod{newTestPeer(sw, 0)}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+	key := swarmKey(sw)
+
+	f.sync(key)
+	sw = f.refresh(sw)
+	if events := f.events(); hasEvent(events, ReasonScalingStarted) {
+		t.Fatalf("expected no scaling on a settled swarm, got %v", events)
+	}
+
+	sw.Spec.Replicas = 3
+	f.updateSwarm(sw)
+	f.sync(key)
+	sw = f.refresh(sw)
+	if events := f.events(); !hasEvent(events, ReasonScalingStarted) {
+		t.Fatalf("expected ScalingStarted, got %v", events)
+	}
+	if !meta.IsStatusConditionTrue(sw.Status.Conditions, swarmv1alpha1.ConditionScaling) {
+		t.Fatalf("expected the Scaling condition raised, got %v", sw.Status.Conditions)
+	}
+
+	// the new peers are observed
+	for i := 1; i < 3; i++ {
+		pod := newTestPeer(sw, i)
+		f.addPod(pod)
+		f.controller.addPod(pod)
+	}
+	f.sync(key)
+	sw = f.refresh(sw)
+	events := f.events()
+	if hasEvent(events, ReasonScalingStarted) || !hasEvent(events, ReasonScalingCompleted) {
+		t.Fatalf("expected ScalingCompleted only, got %v", events)
+	}
+	if meta.IsStatusConditionTrue(sw.Status.Conditions, swarmv1alpha1.ConditionScaling) {
+		t.Errorf("expected the Scaling condition cleared, got %v", sw.Status.Conditions)
+	}
+}
+
+func TestQuorumLostEvent(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	var pods []*corev1.Pod
+	for i := 0; i < 3; i++ {
+		pod := newTestPeer(sw, i)
+		pod.Labels[peerRoleLabel] = swarmv1alpha1.RoleVoter
+		pods = append(pods, pod)
+	}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+	key := swarmKey(sw)
+
+	f.sync(key)
+	sw = f.refresh(sw)
+	if events := f.events(); hasEvent(events, ReasonQuorumLost) {
+		t.Fatalf("expected quorum held, got %v", events)
+	}
+
+	for _, pod := range pods[1:] {
+		unready := pod.DeepCopy()
+		unready.Status.Conditions[0].Status = corev1.ConditionFalse
+		unready.Status.Conditions[0].LastTransitionTime = metav1.Now()
+		f.addPod(unready)
+	}
+	f.sync(key)
+	sw = f.refresh(sw)
+	if events := f.events(); !hasEvent(events, ReasonQuorumLost) {
+		t.Fatalf("expected QuorumLost, got %v", events)
+	}
+	if !meta.IsStatusConditionTrue(sw.Status.Conditions, swarmv1alpha1.ConditionQuorumLost) {
+		t.Fatalf("expected the QuorumLost condition raised, got %v", sw.Status.Conditions)
+	}
+
+	// still lost, no new event
+	f.sync(key)
+	if events := f.events(); hasEvent(events, ReasonQuorumLost) {
+		t.Errorf("expected QuorumLost once, got %v", events)
+	}
+}
+
+func TestQuorumNotLostWhileBootstrapping(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+
+	f.sync(swarmKey(sw))
+	if events := f.events(); hasEvent(events, ReasonQuorumLost) {
+		t.Errorf("expected no QuorumLost before quorum was held, got %v", events)
+	}
+}
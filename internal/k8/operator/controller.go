@@ -3,25 +3,33 @@ package operator
 import (
 	"context"
 	"fmt"
+	"github.com/marcosQuesada/swarm/pkg/apis/swarm"
 	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
 	clientset "github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informer "k8s.io/client-go/informers/core/v1"
+	policyv1informer "k8s.io/client-go/informers/policy/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
-	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corev1lister "k8s.io/client-go/listers/core/v1"
+	policyv1lister "k8s.io/client-go/listers/policy/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	swarmScheme "github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned/scheme"
 	informers "github.com/marcosQuesada/swarm/pkg/generated/informers/externalversions/swarm/v1alpha1"
 	listers "github.com/marcosQuesada/swarm/pkg/generated/listers/swarm/v1alpha1"
 	"k8s.io/client-go/tools/record"
@@ -29,6 +37,13 @@ import (
 
 const controllerAgentName = "swarm-controller"
 
+// swarmLabel is stamped on every pod the controller creates, its value is the
+// owner Swarm name. The pod informer only watches pods carrying it.
+const swarmLabel = swarm.GroupName + "/swarm"
+
+// defaultReconcileTimeout bounds a single reconcile when no timeout is configured
+const defaultReconcileTimeout = time.Second * 30
+
 // Controller is the controller implementation for At resources
 type Controller struct {
 	kubeClientset  kubernetes.Interface
@@ -40,6 +55,24 @@ type Controller struct {
 	podLister  corev1lister.PodLister
 	podsSynced cache.InformerSynced
 
+	pvcLister  corev1lister.PersistentVolumeClaimLister
+	pvcsSynced cache.InformerSynced
+
+	pdbLister  policyv1lister.PodDisruptionBudgetLister
+	pdbsSynced cache.InformerSynced
+
+	nodeLister  corev1lister.NodeLister
+	nodesSynced cache.InformerSynced
+
+	swarmPeerLister  listers.SwarmPeerLister
+	swarmPeersSynced cache.InformerSynced
+
+	serviceLister  corev1lister.ServiceLister
+	servicesSynced cache.InformerSynced
+
+	configMapLister  corev1lister.ConfigMapLister
+	configMapsSynced cache.InformerSynced
+
 	// workqueue is a rate limited work queue. This is used to queue work to be
 	// processed instead of performing it as soon as a change happens. This
 	// means we can ensure we only process a fixed amount of resources at a
@@ -49,6 +82,21 @@ type Controller struct {
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
+	// reconcileTimeout is the deadline applied to each syncHandler call, it
+	// bounds every API call made while reconciling a single key.
+	reconcileTimeout time.Duration
+	// drainer tracks in-flight reconciles so shutdown can wait for them.
+	drainer *drainer
+	// expectations holds pod operations not yet observed on the pod cache.
+	expectations *expectations
+	// pool registers peers replaced by rolling updates.
+	pool Pool
+	// peerClient queries the peer HTTP endpoints.
+	peerClient *http.Client
+	// swarmIndexer looks swarms up by peer ID, address and selector labels.
+	swarmIndexer cache.Indexer
+	// ipam allocates peer addresses from swarm address pools.
+	ipam *ipam
 }
 
 // NewController returns a new swarm controller
@@ -57,53 +105,145 @@ func NewController(
 	swarmClientset clientset.Interface,
 	podInformer corev1informer.PodInformer,
 	swarmInformer informers.SwarmInformer,
+	pvcInformer corev1informer.PersistentVolumeClaimInformer,
+	pdbInformer policyv1informer.PodDisruptionBudgetInformer,
+	nodeInformer corev1informer.NodeInformer,
+	swarmPeerInformer informers.SwarmPeerInformer,
+	serviceInformer corev1informer.ServiceInformer,
+	configMapInformer corev1informer.ConfigMapInformer,
+	pool Pool,
+	reconcileTimeout time.Duration,
 ) *Controller {
 
 	// Create event broadcaster
-	// Add swarm-controller types to the default Kubernetes Scheme so Events can be
-	// logged for swarm-controller types.
-	utilruntime.Must(swarmScheme.AddToScheme(scheme.Scheme))
-	klog.V(4).Info("Creating event broadcaster")
-	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(klog.Infof)
-	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClientset.CoreV1().Events("")})
-	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+	recorder := NewEventRecorder(kubeClientset, controllerAgentName)
+
+	if reconcileTimeout <= 0 {
+		reconcileTimeout = defaultReconcileTimeout
+	}
+
+	// swarms are indexed by peer ID, address and selector labels, so peers
+	// stay unique and pods map back to their swarms without listing them all
+	if err := swarmInformer.Informer().AddIndexers(SwarmIndexers()); err != nil {
+		utilruntime.HandleError(fmt.Errorf("adding swarm indexers: %v", err))
+	}
 
 	controller := &Controller{
-		kubeClientset:  kubeClientset,
-		swarmClientset: swarmClientset,
-		swarmLister:    swarmInformer.Lister(),
-		swarmsSynced:   swarmInformer.Informer().HasSynced,
-		podLister:      podInformer.Lister(),
-		podsSynced:     podInformer.Informer().HasSynced,
-		workqueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Swarms"),
-		recorder:       recorder,
+		kubeClientset:    kubeClientset,
+		swarmClientset:   swarmClientset,
+		swarmLister:      swarmInformer.Lister(),
+		swarmsSynced:     swarmInformer.Informer().HasSynced,
+		podLister:        podInformer.Lister(),
+		podsSynced:       podInformer.Informer().HasSynced,
+		pvcLister:        pvcInformer.Lister(),
+		pvcsSynced:       pvcInformer.Informer().HasSynced,
+		pdbLister:        pdbInformer.Lister(),
+		pdbsSynced:       pdbInformer.Informer().HasSynced,
+		nodeLister:       nodeInformer.Lister(),
+		nodesSynced:      nodeInformer.Informer().HasSynced,
+		swarmPeerLister:  swarmPeerInformer.Lister(),
+		swarmPeersSynced: swarmPeerInformer.Informer().HasSynced,
+		serviceLister:    serviceInformer.Lister(),
+		servicesSynced:   serviceInformer.Informer().HasSynced,
+		configMapLister:  configMapInformer.Lister(),
+		configMapsSynced: configMapInformer.Informer().HasSynced,
+		workqueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Swarms"),
+		recorder:         recorder,
+		reconcileTimeout: reconcileTimeout,
+		drainer:          newDrainer(),
+		expectations:     newExpectations(),
+		pool:             pool,
+		peerClient:       newPeerClient(),
+		swarmIndexer:     swarmInformer.Informer().GetIndexer(),
+		ipam:             newIPAM(swarmInformer.Informer().GetIndexer()),
 	}
 
 	klog.Info("Setting up event handlers")
-	// Set up an event handler for when At resources change
+	// Set up an event handler for when At resources change, no-op updates
+	// as resyncs and our own status writes are skipped
+	swarmPredicate := SwarmUpdatePredicate()
 	swarmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.enqueueSwarm,
 		UpdateFunc: func(old, new interface{}) {
+			if !swarmPredicate(old, new) {
+				skippedUpdates.WithLabelValues(controllerAgentName, "swarm").Inc()
+				return
+			}
 			controller.enqueueSwarm(new)
 		},
+		DeleteFunc: controller.deleteSwarm,
 	})
 	// Set up an event handler for when Pod resources change
+	podPredicate := PodUpdatePredicate()
 	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueuePod,
+		AddFunc: controller.addPod,
 		UpdateFunc: func(old, new interface{}) {
+			if !podPredicate(old, new) {
+				skippedUpdates.WithLabelValues(controllerAgentName, "pod").Inc()
+				return
+			}
 			klog.V(4).Info("UPDATE POD")
 			controller.enqueuePod(new)
 		},
+		DeleteFunc: controller.deletePod,
+	})
+	// Peer claims are not always owned by their Swarm, they are mapped back
+	// through their swarm label.
+	pvcInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+			controller.enqueueLabeled(new)
+		},
+		DeleteFunc: controller.enqueueLabeled,
+	})
+	pdbInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+			controller.enqueueLabeled(new)
+		},
+		DeleteFunc: controller.enqueueLabeled,
+	})
+	swarmPeerInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+			controller.enqueueLabeled(new)
+		},
+		DeleteFunc: controller.enqueueLabeled,
+	})
+	serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+			controller.enqueueLabeled(new)
+		},
+		DeleteFunc: controller.enqueueLabeled,
+	})
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			if !resourceVersionChanged(old, new) {
+				return
+			}
+			controller.enqueueLabeled(new)
+		},
+		DeleteFunc: controller.enqueueLabeled,
 	})
 	return controller
 }
 
 // Run will set up the event handlers for types we are interested in, as well
-// as syncing informer caches and starting workers. It will block until stopCh
-// is closed, at which point it will shutdown the workqueue and wait for
-// workers to finish processing their current work items.
-func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
+// as syncing informer caches and starting workers. It will block until ctx
+// is cancelled, at which point it will stop accepting new work and wait up to
+// gracePeriod for workers to finish processing their current work items.
+// Work items still running then are cancelled, Run returns once every worker
+// exited so nothing writes to the API server afterwards.
+func (c *Controller) Run(ctx context.Context, threadiness int, gracePeriod time.Duration) error {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
 
@@ -112,22 +252,74 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.swarmsSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.swarmsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.podsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.pvcsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.pdbsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.nodesSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
-	if ok := cache.WaitForCacheSync(stopCh, c.podsSynced); !ok {
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.swarmPeersSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.servicesSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.configMapsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	// membership changes left pending by a previous run are settled before
+	// any swarm is reconciled, those failing are settled again on their
+	// swarm next change
+	if resumer, ok := c.pool.(Resumer); ok {
+		swarms, err := c.swarmLister.List(labels.Everything())
+		if err != nil {
+			return err
+		}
+		if err := resumer.Resume(ctx, swarms); err != nil {
+			klog.Errorf("resuming pending membership changes: %v", err)
+		}
+	}
+
+	// Workers run on their own context, in-flight reconciles must not be
+	// aborted as soon as ctx is cancelled but get gracePeriod to complete,
+	// including their status updates.
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
 
 	klog.Info("Starting workers")
 	// Launch two workers to process At resources
+	var workers sync.WaitGroup
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			wait.UntilWithContext(workCtx, c.runWorker, time.Second)
+		}()
 	}
 
 	klog.Info("Started workers")
-	<-stopCh
-	klog.Info("Shutting down workers")
+	<-ctx.Done()
+	klog.Infof("Shutting down workers, draining in-flight reconciles for up to %s", gracePeriod)
+
+	c.workqueue.ShutDown()
+	if abandoned := c.drainer.drain(gracePeriod); len(abandoned) > 0 {
+		klog.Warningf("Grace period expired, aborting in-flight reconciles: %v", abandoned)
+	}
+	// workers only exit once their context is done, aborted reconciles must
+	// return before the caller releases the leader lease
+	cancelWork()
+	workers.Wait()
+	klog.Info("Workers drained")
 
 	return nil
 }
@@ -135,14 +327,14 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 // runWorker is a long-running function that will continually call the
 // processNextWorkItem function in order to read and process a message on the
 // workqueue.
-func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
 	}
 }
 
 // processNextWorkItem will read a single work item off the workqueue and
 // attempt to process it, by calling the syncHandler.
-func (c *Controller) processNextWorkItem() bool {
+func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	obj, shutdown := c.workqueue.Get()
 
 	if shutdown {
@@ -173,9 +365,18 @@ func (c *Controller) processNextWorkItem() bool {
 			utilruntime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
 			return nil
 		}
+		// Once shutdown started queued keys are not processed anymore, they
+		// will be picked up again on the next start from the informer cache.
+		if !c.drainer.begin(key) {
+			klog.Infof("Abandoning '%s' on shutdown", key)
+			return nil
+		}
+		defer c.drainer.end(key)
 		// Run the syncHandler, passing it the namespace/name string of the
-		// At resource to be synced.
-		if when, err := c.syncHandler(key); err != nil {
+		// At resource to be synced, bounded by the reconcile deadline.
+		syncCtx, cancel := context.WithTimeout(ctx, c.reconcileTimeout)
+		defer cancel()
+		if when, err := c.syncHandler(syncCtx, key); err != nil {
 			// Put the item back on the workqueue to handle any transient errors.
 			c.workqueue.AddRateLimited(key)
 			return fmt.Errorf("error syncing '%s': %s, requeuing", key, err.Error())
@@ -202,7 +403,7 @@ func (c *Controller) processNextWorkItem() bool {
 // converge the two. It then updates the Status block of the At resource
 // with the current status of the resource. It returns how long to wait
 // until the schedule is due.
-func (c *Controller) syncHandler(key string) (time.Duration, error) {
+func (c *Controller) syncHandler(ctx context.Context, key string) (time.Duration, error) {
 	klog.Infof("=== Reconciling Swarm %s", key)
 
 	// Convert the namespace/name string into a distinct namespace and name
@@ -219,6 +420,7 @@ func (c *Controller) syncHandler(key string) (time.Duration, error) {
 		// processing.
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("at '%s' in work queue no longer exists", key))
+			c.expectations.delete(key)
 			return time.Duration(0), nil
 		}
 
@@ -228,6 +430,7 @@ func (c *Controller) syncHandler(key string) (time.Duration, error) {
 	// Clone because the original object is owned by the lister.
 	instance := original.DeepCopy()
 	//spew.Dump(instance)
+	var requeue time.Duration
 
 	// If no phase set, default to pending (the initial phase):
 	if instance.Status.Phase == "" {
@@ -259,31 +462,140 @@ func (c *Controller) syncHandler(key string) (time.Duration, error) {
 	case swarmv1alpha1.PhaseRunning:
 		klog.Infof("instance %s: Phase: RUNNING", key)
 
-		pod := newPodForCR(instance)
+		selector, err := swarmSelector(instance)
+		if err != nil {
+			utilruntime.HandleError(fmt.Errorf("instance %s: invalid selector: %v", key, err))
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonInvalidSpec, "Invalid selector: %v", err)
+			return time.Duration(0), nil
+		}
 
-		// Set At instance as the owner and controller
-		owner := metav1.NewControllerRef(instance, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))
-		pod.ObjectMeta.OwnerReferences = append(pod.ObjectMeta.OwnerReferences, *owner)
+		if err := validateRoles(instance); err != nil {
+			utilruntime.HandleError(fmt.Errorf("instance %s: invalid roles: %v", key, err))
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonInvalidSpec, "Invalid roles: %v", err)
+			return time.Duration(0), nil
+		}
 
-		// Try to see if the pod already exists and if not
-		// (which we expect) then create a one-shot pod as per spec:
-		found, err := c.kubeClientset.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
-		if err != nil && errors.IsNotFound(err) {
-			found, err = c.kubeClientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{})
-			if err != nil {
+		collisions, err := peerCollisions(c.swarmIndexer, key, instance)
+		if err != nil {
+			return time.Duration(0), err
+		}
+		if len(collisions) > 0 {
+			utilruntime.HandleError(fmt.Errorf("instance %s: peer collisions: %s", key, strings.Join(collisions, ", ")))
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonPeerCollision, "Spec peers collide with other swarms: %s", strings.Join(collisions, ", "))
+			return time.Duration(0), nil
+		}
+
+		if err := validateAddressPool(instance); err != nil {
+			utilruntime.HandleError(fmt.Errorf("instance %s: invalid address pool: %v", key, err))
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonInvalidSpec, "Invalid address pool: %v", err)
+			return time.Duration(0), nil
+		}
+
+		peer, err := newPodForCR(instance, 0)
+		if err != nil {
+			return time.Duration(0), err
+		}
+		if !selector.Matches(labels.Set(peer.Labels)) {
+			utilruntime.HandleError(fmt.Errorf("instance %s: selector %s does not match peer labels", key, selector))
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonInvalidSpec, "Selector %s does not match peer labels", selector)
+			return time.Duration(0), nil
+		}
+
+		// Pod operations issued by a previous reconcile are not yet on the
+		// cache, acting now would duplicate them. The pod events requeue us.
+		if !c.expectations.satisfied(key) {
+			klog.V(4).Infof("instance %s: waiting for pod operations to be observed", key)
+			return time.Duration(0), nil
+		}
+
+		pods, err := c.podLister.Pods(instance.Namespace).List(labels.Everything())
+		if err != nil {
+			return time.Duration(0), err
+		}
+		claimed, err := c.claimPods(ctx, instance, selector, pods)
+		if err != nil {
+			return time.Duration(0), err
+		}
+
+		instance.Status.Replicas = int32(len(activePods(claimed)))
+		instance.Status.Selector = selector.String()
+		c.updateScalingStatus(key, instance)
+		c.updatePlacementStatus(instance, activePods(claimed))
+		if err := updateRevisionStatus(instance, peerPods(instance, activePods(claimed))); err != nil {
+			return time.Duration(0), err
+		}
+		if instance.Status.UpdateRevision != original.Status.UpdateRevision {
+			if err := c.saveRevision(ctx, key, instance); err != nil {
+				return time.Duration(0), err
+			}
+		}
+		if instance.Status.CurrentRevision != original.Status.CurrentRevision && original.Status.CurrentRevision != "" {
+			if err := c.pruneRevisions(ctx, key, instance); err != nil {
 				return time.Duration(0), err
 			}
-			klog.Infof("instance %s: pod launched: name=%s", key, pod.Name)
-		} else if err != nil {
-			// requeue with error
+		}
+
+		// peers left without address are retried, other swarms releasing
+		// addresses do not requeue us
+		var addressRetry time.Duration
+		addresses, missing, err := c.ipam.allocate(key, instance)
+		if err != nil {
 			return time.Duration(0), err
-		} else if found.Status.Phase == corev1.PodFailed || found.Status.Phase == corev1.PodSucceeded {
-			klog.Infof("instance %s: container terminated: reason=%q message=%q", key, found.Status.Reason, found.Status.Message)
-			instance.Status.Phase = swarmv1alpha1.PhaseDone
-		} else {
-			// don't requeue because it will happen automatically when the pod status changes
-			return time.Duration(0), nil
 		}
+		instance.Status.Addresses = addresses
+		if missing > 0 {
+			klog.Warningf("instance %s: address pool %s exhausted, %d peers without address", key, instance.Spec.AddressPool.CIDR, missing)
+			c.recorder.Eventf(instance, corev1.EventTypeWarning, ReasonAddressPoolExhausted, "Address pool %s exhausted, %d peers without address", instance.Spec.AddressPool.CIDR, missing)
+			addressRetry = addressRetryPeriod
+		}
+		if err := c.annotateAddresses(ctx, instance, peerPods(instance, activePods(claimed))); err != nil {
+			return time.Duration(0), err
+		}
+
+		volumes, err := c.reconcileVolumes(ctx, key, instance)
+		if err != nil {
+			return time.Duration(0), err
+		}
+		instance.Status.Volumes = volumes
+
+		if err := c.reconcileSwarmPeers(ctx, key, instance, peerPods(instance, claimed)); err != nil {
+			return time.Duration(0), err
+		}
+		if err := c.reconcileDisruptionBudget(ctx, key, instance); err != nil {
+			return time.Duration(0), err
+		}
+
+		if err := c.reconcilePeersService(ctx, key, instance); err != nil {
+			return time.Duration(0), err
+		}
+		if err := c.reconcilePeerList(ctx, key, instance, peerPods(instance, activePods(claimed))); err != nil {
+			return time.Duration(0), err
+		}
+
+		if err := c.reconcilePeers(ctx, key, instance, claimed); err != nil {
+			return time.Duration(0), err
+		}
+
+		// unhealthy peers are replaced once due, requeue for the next one
+		requeue, err = c.healPeers(ctx, key, instance, peerPods(instance, claimed))
+		if err != nil {
+			return time.Duration(0), err
+		}
+		c.updateQuorumStatus(key, instance, peerPods(instance, activePods(claimed)))
+
+		// peers are queried periodically for leadership
+		probe, err := c.reconcileLeader(ctx, key, instance, peerPods(instance, activePods(claimed)))
+		if err != nil {
+			return time.Duration(0), err
+		}
+		requeue = sooner(requeue, probe)
+		requeue = sooner(requeue, addressRetry)
+		if c.expectations.satisfied(key) {
+			if err := c.rollPeers(ctx, key, instance, peerPods(instance, activePods(claimed))); err != nil {
+				return time.Duration(0), err
+			}
+		}
+		// don't requeue because it will happen automatically when the pod status changes
 	case swarmv1alpha1.PhaseDone:
 		klog.Infof("instance %s: phase: DONE", key)
 		return time.Duration(0), nil
@@ -292,23 +604,100 @@ func (c *Controller) syncHandler(key string) (time.Duration, error) {
 		return time.Duration(0), nil
 	}
 
-	if !reflect.DeepEqual(original, instance) {
+	if !reflect.DeepEqual(original.Status, instance.Status) {
 		// Update the swarm instance, setting the status to the respective phase:
-		_, err = c.swarmClientset.K8slabV1alpha1().Swarms(instance.Namespace).UpdateStatus(context.Background(), instance, metav1.UpdateOptions{})
-		if err != nil {
+		if err = c.updateStatus(ctx, original, instance.Status); err != nil {
 			return time.Duration(0), err
 		}
 	}
 
-	// Don't requeue. We should be reconcile because either the pod or the CR changes.
-	return time.Duration(0), nil
+	// Don't requeue unless a peer heals later, otherwise we are reconciled
+	// because either the pod or the CR changes.
+	return requeue, nil
+}
+
+// updateStatus writes status on the swarm, the status block is owned by the
+// controller while spec and metadata belong to users. On conflict the swarm is
+// re-read from the API and the status applied again on top of it, instead of
+// requeueing the whole reconcile with backoff.
+func (c *Controller) updateStatus(ctx context.Context, sw *swarmv1alpha1.Swarm, status swarmv1alpha1.SwarmStatus) error {
+	client := c.swarmClientset.K8slabV1alpha1().Swarms(sw.Namespace)
+	current := sw.DeepCopy()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current.Status = *status.DeepCopy()
+		_, err := client.UpdateStatus(ctx, current, metav1.UpdateOptions{})
+		if !errors.IsConflict(err) {
+			return err
+		}
+
+		klog.V(4).Infof("conflict updating swarm %s/%s status, retrying", sw.Namespace, sw.Name)
+		fresh, getErr := client.Get(ctx, sw.Name, metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+		current = fresh
+
+		return err
+	})
+}
+
+// claimPods reconciles the ownership of pods against the swarm selector and
+// returns the ones the swarm owns.
+func (c *Controller) claimPods(ctx context.Context, sw *swarmv1alpha1.Swarm, selector labels.Selector, pods []*corev1.Pod) ([]*corev1.Pod, error) {
+	canAdopt := func(ctx context.Context) error {
+		fresh, err := c.swarmClientset.K8slabV1alpha1().Swarms(sw.Namespace).Get(ctx, sw.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if fresh.UID != sw.UID {
+			return fmt.Errorf("original swarm %s/%s is gone: got uid %s, wanted %s", sw.Namespace, sw.Name, fresh.UID, sw.UID)
+		}
+		if fresh.DeletionTimestamp != nil {
+			return fmt.Errorf("swarm %s/%s has just been deleted at %v", sw.Namespace, sw.Name, fresh.DeletionTimestamp)
+		}
+		return nil
+	}
+
+	// the pod cache only holds swarm labeled pods, orphans a custom selector
+	// matches without the label are read from the API
+	if sw.Spec.Selector != nil && sw.DeletionTimestamp == nil {
+		orphans, err := c.unlabeledOrphans(ctx, sw, selector)
+		if err != nil {
+			return nil, err
+		}
+		pods = append(append([]*corev1.Pod{}, pods...), orphans...)
+	}
+
+	return newPodRefManager(c.kubeClientset, c.recorder, sw, selector, canAdopt).claimPods(ctx, pods)
+}
+
+// unlabeledOrphans lists the pods selector matches that have no controller
+// nor swarm label
+func (c *Controller) unlabeledOrphans(ctx context.Context, sw *swarmv1alpha1.Swarm, selector labels.Selector) ([]*corev1.Pod, error) {
+	unlabeled, err := labels.NewRequirement(swarmLabel, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.kubeClientset.CoreV1().Pods(sw.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.Add(*unlabeled).String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*corev1.Pod
+	for i := range list.Items {
+		if metav1.GetControllerOf(&list.Items[i]) == nil {
+			orphans = append(orphans, &list.Items[i])
+		}
+	}
+	return orphans, nil
 }
 
 // enqueueSwarm takes a At resource and converts it into a namespace/name
 // string which is then put onto the work queue. This method should *not* be
 // passed resources of any type other than At.
 func (c *Controller) enqueueSwarm(obj interface{}) {
-	klog.Info("Enqueue swarm")
+	klog.V(4).Info("Enqueue swarm")
 	var key string
 	var err error
 	if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
@@ -318,10 +707,83 @@ func (c *Controller) enqueueSwarm(obj interface{}) {
 	c.workqueue.Add(key)
 }
 
+// deleteSwarm drops the controller-side state of a deleted Swarm, its pods
+// are removed by the garbage collector through their owner references.
+func (c *Controller) deleteSwarm(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	klog.Infof("Swarm %s deleted", key)
+	c.expectations.delete(key)
+	c.ipam.release(key)
+	c.workqueue.Forget(key)
+}
+
+// enqueueLabeled enqueues the Swarm named on the swarm label of obj
+func (c *Controller) enqueueLabeled(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	name, ok := o.GetLabels()[swarmLabel]
+	if !ok {
+		return
+	}
+	c.workqueue.Add(o.GetNamespace() + "/" + name)
+}
+
+// addPod lowers the creation expectations of the owner Swarm, then enqueues
+// it as any other pod change.
+func (c *Controller) addPod(obj interface{}) {
+	pod := obj.(*corev1.Pod)
+	if ownerRef := metav1.GetControllerOf(pod); ownerRef != nil && ownerRef.Kind == "Swarm" {
+		c.expectations.creationObserved(pod.Namespace + "/" + ownerRef.Name)
+	}
+	c.enqueuePod(obj)
+}
+
+// deletePod lowers the deletion expectations of the owner Swarm and enqueues
+// it right away, so a lost peer is recreated without waiting for a resync.
+func (c *Controller) deletePod(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding deleted pod, invalid type"))
+			return
+		}
+		pod, ok = tombstone.Obj.(*corev1.Pod)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("error decoding deleted pod tombstone, invalid type"))
+			return
+		}
+	}
+
+	ownerRef := metav1.GetControllerOf(pod)
+	if ownerRef == nil || ownerRef.Kind != "Swarm" {
+		return
+	}
+
+	key := pod.Namespace + "/" + ownerRef.Name
+	klog.Infof("Pod %s of Swarm %s deleted", pod.Name, key)
+	if sw, err := c.swarmLister.Swarms(pod.Namespace).Get(ownerRef.Name); err == nil && sw.UID == ownerRef.UID {
+		c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonPeerDeleted, "Peer pod %s deleted", pod.Name)
+	}
+	c.expectations.deletionObserved(key)
+	c.workqueue.Add(key)
+}
+
 // enqueueSwarm a pod and checks that the owner reference points to an At object. It then
 // enqueues this At object.
 func (c *Controller) enqueuePod(obj interface{}) {
-	klog.Info("Enqueue pod")
+	klog.V(4).Info("Enqueue pod")
 	pod, ok := obj.(*corev1.Pod)
 	if !ok {
 		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
@@ -337,46 +799,106 @@ func (c *Controller) enqueuePod(obj interface{}) {
 		klog.V(4).Infof("Recovered deleted pod '%s' from tombstone", pod.GetName())
 	}
 
-	klog.Infof("Handling pod '%s'", pod.GetName())
+	klog.V(4).Infof("Handling pod '%s'", pod.GetName())
 	if ownerRef := metav1.GetControllerOf(pod); ownerRef != nil {
 		if ownerRef.Kind != "Swarm" {
 			klog.V(4).Infof("ignoring pod '%s' with owner %s", pod.GetName(), ownerRef.Kind)
 			return
 		}
 
-		swarm, err := c.swarmLister.Swarms(pod.GetNamespace()).Get(ownerRef.Name)
+		sw, err := c.swarmLister.Swarms(pod.GetNamespace()).Get(ownerRef.Name)
 		if err != nil {
 			klog.V(4).Infof("ignoring orphaned pod '%s' of Swarm '%s'", pod.GetSelfLink(), ownerRef.Name)
 			return
 		}
 
-		klog.Infof("enqueuing Swarm %s/%s because pod changed", swarm.Namespace, swarm.Name)
-		c.enqueueSwarm(swarm)
+		klog.V(4).Infof("enqueuing Swarm %s/%s because pod changed", sw.Namespace, sw.Name)
+		c.enqueueSwarm(sw)
+		return
+	}
+
+	// orphan pod, enqueue the swarms that may adopt it
+	swarms, err := swarmsForPod(c.swarmIndexer, pod)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, sw := range swarms {
+		klog.V(4).Infof("enqueuing Swarm %s/%s because orphan pod %s matches", sw.Namespace, sw.Name, pod.GetName())
+		c.enqueueSwarm(sw)
 	}
 }
 
-// newPodForCR returns a busybox pod with the same name/namespace as the cr
-func newPodForCR(cr *swarmv1alpha1.Swarm) *corev1.Pod {
-	labels := map[string]string{
-		"app": cr.Name,
+// newPodForCR returns the pod of the peer at index built from the cr template,
+// a busybox placeholder when it has none, owned by the cr
+func newPodForCR(cr *swarmv1alpha1.Swarm, index int) (*corev1.Pod, error) {
+	revision, err := peerRevision(cr)
+	if err != nil {
+		return nil, err
 	}
-	return &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      cr.Name + "-pod",
-			Namespace: cr.Namespace,
-			Labels:    labels,
-		},
-		Spec: corev1.PodSpec{
-			Containers: []corev1.Container{
-				{
-					Name:  "busybox",
-					Image: "busybox",
-					//	Command: strings.Split(cr.Spec.Command, " "),
-				},
+
+	podLabels := map[string]string{}
+	var annotations map[string]string
+	spec := corev1.PodSpec{
+		Containers: []corev1.Container{
+			{
+				Name:  "busybox",
+				Image: "busybox",
+				//	Command: strings.Split(cr.Spec.Command, " "),
 			},
-			RestartPolicy: corev1.RestartPolicyOnFailure,
 		},
+		RestartPolicy: corev1.RestartPolicyOnFailure,
+	}
+	if cr.Spec.Template != nil {
+		for k, v := range cr.Spec.Template.Labels {
+			podLabels[k] = v
+		}
+		annotations = cr.Spec.Template.Annotations
+		spec = *cr.Spec.Template.Spec.DeepCopy()
+	}
+	podLabels["app"] = cr.Name
+	podLabels[swarmLabel] = cr.Name
+	podLabels[peerIndexLabel] = strconv.Itoa(index)
+	podLabels[peerRevisionLabel] = revision
+	podAnnotations := map[string]string{}
+	for k, v := range annotations {
+		podAnnotations[k] = v
+	}
+	podAnnotations[peerIDAnnotation] = peerID(cr, index)
+	if address := peerAddress(cr, index); address != "" {
+		podAnnotations[peerAddressAnnotation] = address
+	}
+	if cr.Spec.Selector != nil {
+		for k, v := range cr.Spec.Selector.MatchLabels {
+			podLabels[k] = v
+		}
+	}
+	// Set At instance as the owner and controller
+	owner := metav1.NewControllerRef(cr, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            peerPodName(cr, index),
+			Namespace:       cr.Namespace,
+			Labels:          podLabels,
+			Annotations:     podAnnotations,
+			OwnerReferences: []metav1.OwnerReference{*owner},
+		},
+		Spec: spec,
+	}
+	addPeerVolumes(cr, pod, index)
+	addPeerPlacement(cr, pod)
+	addPeerIdentity(cr, pod, index)
+	addPeerList(cr, pod)
+
+	return pod, nil
+}
+
+// sooner returns the shortest of two requeue delays, zero meaning none
+func sooner(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
 	}
+	return a
 }
 
 // timeUntilSchedule parses the schedule string and returns the time until the schedule.
@@ -0,0 +1,394 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	"github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned/fake"
+	informers "github.com/marcosQuesada/swarm/pkg/generated/informers/externalversions"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+var alwaysReady = func() bool { return true }
+
+// fixture is a Controller on fake clientsets whose informer caches are fed
+// by hand, reconciles are driven by calling the handlers and syncHandler
+type fixture struct {
+	t *testing.T
+
+	kubeClient     *k8sfake.Clientset
+	swarmClient    *fake.Clientset
+	kubeInformers  kubeinformers.SharedInformerFactory
+	swarmInformers informers.SharedInformerFactory
+	recorder       *record.FakeRecorder
+	controller     *Controller
+	// peers serves the peer endpoints the controller queries
+	peers *peerNetwork
+}
+
+func newFixture(t *testing.T, pool Pool, swarms []*swarmv1alpha1.Swarm, pods []*corev1.Pod) *fixture {
+	t.Helper()
+
+	var kubeObjects, swarmObjects []runtime.Object
+	for _, pod := range pods {
+		kubeObjects = append(kubeObjects, pod)
+	}
+	for _, sw := range swarms {
+		swarmObjects = append(swarmObjects, sw)
+	}
+
+	f := &fixture{
+		t:           t,
+		kubeClient:  k8sfake.NewSimpleClientset(kubeObjects...),
+		swarmClient: fake.NewSimpleClientset(swarmObjects...),
+		recorder:    record.NewFakeRecorder(100),
+		peers:       newPeerNetwork(t),
+	}
+	f.kubeInformers = kubeinformers.NewSharedInformerFactory(f.kubeClient, 0)
+	f.swarmInformers = informers.NewSharedInformerFactory(f.swarmClient, 0)
+	if pool == nil {
+		pool = NewPool()
+	}
+
+	c := NewController(
+		f.kubeClient,
+		f.swarmClient,
+		f.kubeInformers.Core().V1().Pods(),
+		f.swarmInformers.K8slab().V1alpha1().Swarms(),
+		f.kubeInformers.Core().V1().PersistentVolumeClaims(),
+		f.kubeInformers.Policy().V1().PodDisruptionBudgets(),
+		f.kubeInformers.Core().V1().Nodes(),
+		f.swarmInformers.K8slab().V1alpha1().SwarmPeers(),
+		f.kubeInformers.Core().V1().Services(),
+		f.kubeInformers.Core().V1().ConfigMaps(),
+		pool,
+		time.Second*5,
+	)
+	c.recorder = f.recorder
+	c.peerClient.Transport = f.peers.transport()
+	c.swarmsSynced = alwaysReady
+	c.podsSynced = alwaysReady
+	c.pvcsSynced = alwaysReady
+	c.pdbsSynced = alwaysReady
+	c.nodesSynced = alwaysReady
+	c.swarmPeersSynced = alwaysReady
+	c.servicesSynced = alwaysReady
+	c.configMapsSynced = alwaysReady
+	f.controller = c
+
+	for _, sw := range swarms {
+		f.addSwarm(sw)
+	}
+	for _, pod := range pods {
+		f.addPod(pod)
+	}
+	return f
+}
+
+func (f *fixture) addSwarm(sw *swarmv1alpha1.Swarm) {
+	if err := f.swarmInformers.K8slab().V1alpha1().Swarms().Informer().GetIndexer().Add(sw); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// refresh reads the swarm back from the API into the cache, as its update
+// event does
+func (f *fixture) refresh(sw *swarmv1alpha1.Swarm) *swarmv1alpha1.Swarm {
+	f.t.Helper()
+	fresh, err := f.swarmClient.K8slabV1alpha1().Swarms(sw.Namespace).Get(context.Background(), sw.Name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.swarmInformers.K8slab().V1alpha1().Swarms().Informer().GetIndexer().Update(fresh); err != nil {
+		f.t.Fatal(err)
+	}
+	return fresh
+}
+
+// updateSwarm stores sw on the API and the cache, as a user edit does
+func (f *fixture) updateSwarm(sw *swarmv1alpha1.Swarm) {
+	f.t.Helper()
+	if _, err := f.swarmClient.K8slabV1alpha1().Swarms(sw.Namespace).Update(context.Background(), sw, metav1.UpdateOptions{}); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.swarmInformers.K8slab().V1alpha1().Swarms().Informer().GetIndexer().Update(sw); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) addSwarmPeer(peer *swarmv1alpha1.SwarmPeer) {
+	if err := f.swarmClient.Tracker().Add(peer); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.swarmInformers.K8slab().V1alpha1().SwarmPeers().Informer().GetIndexer().Add(peer); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) addPod(pod *corev1.Pod) {
+	if err := f.kubeInformers.Core().V1().Pods().Informer().GetIndexer().Add(pod); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// removePod drops pod from the cache and the API, as its deletion does
+func (f *fixture) removePod(pod *corev1.Pod) {
+	if err := f.kubeInformers.Core().V1().Pods().Informer().GetIndexer().Delete(pod); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.kubeClient.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), pod.Namespace, pod.Name); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+func (f *fixture) sync(key string) time.Duration {
+	f.t.Helper()
+	requeue, err := f.controller.syncHandler(context.Background(), key)
+	if err != nil {
+		f.t.Fatalf("syncing %s: %v", key, err)
+	}
+	return requeue
+}
+
+// queued returns the keys waiting on the workqueue
+func (f *fixture) queued() []string {
+	var keys []string
+	for f.controller.workqueue.Len() > 0 {
+		item, _ := f.controller.workqueue.Get()
+		f.controller.workqueue.Done(item)
+		keys = append(keys, item.(string))
+	}
+	return keys
+}
+
+// events drains the events recorded so far
+func (f *fixture) events() []string {
+	var events []string
+	for {
+		select {
+		case e := <-f.recorder.Events:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// createdPods returns the names of the pods created on the API
+func (f *fixture) createdPods() []string {
+	var names []string
+	for _, action := range f.kubeClient.Actions() {
+		create, ok := action.(core.CreateAction)
+		if !ok || action.GetResource().Resource != "pods" {
+			continue
+		}
+		names = append(names, create.GetObject().(*corev1.Pod).Name)
+	}
+	return names
+}
+
+func hasEvent(events []string, reason string) bool {
+	for _, e := range events {
+		if strings.Contains(e, " "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestSwarm(name string, replicas int) *swarmv1alpha1.Swarm {
+	return &swarmv1alpha1.Swarm{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         metav1.NamespaceDefault,
+			UID:               types.UID(name + "-uid"),
+			CreationTimestamp: metav1.NewTime(time.Unix(1600000000, 0)),
+		},
+		Spec: swarmv1alpha1.SwarmSpec{
+			Replicas: replicas,
+			Size:     replicas,
+		},
+		Status: swarmv1alpha1.SwarmStatus{
+			Phase: swarmv1alpha1.PhaseRunning,
+		},
+	}
+}
+
+// newTestPeer returns the running and ready peer pod at index
+func newTestPeer(sw *swarmv1alpha1.Swarm, index int) *corev1.Pod {
+	pod, err := newPodForCR(sw, index)
+	if err != nil {
+		panic(err)
+	}
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		PodIP: fmt.Sprintf("10.0.0.%d", index+1),
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		},
+	}
+	return pod
+}
+
+func swarmKey(sw *swarmv1alpha1.Swarm) string {
+	return sw.Namespace + "/" + sw.Name
+}
+
+func TestDeletedPeerIsRecreated(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	pods := []*corev1.Pod{newTestPeer(sw, 0), newTestPeer(sw, 1)}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	f.removePod(pods[1])
+	f.controller.deletePod(pods[1])
+
+	if keys := f.queued(); len(keys) != 1 || keys[0] != swarmKey(sw) {
+		t.Fatalf("expected %s queued on pod deletion, got %v", swarmKey(sw), keys)
+	}
+	f.sync(swarmKey(sw))
+
+	if created := f.createdPods(); len(created) != 1 || created[0] != "foo-1" {
+		t.Fatalf("expected foo-1 recreated, got %v", created)
+	}
+	events := f.events()
+	if !hasEvent(events, ReasonPeerDeleted) || !hasEvent(events, ReasonPeerCreated) {
+		t.Errorf("expected PeerDeleted and PeerCreated events, got %v", events)
+	}
+	if f.controller.expectations.satisfied(swarmKey(sw)) {
+		t.Error("expected the recreation to be pending until observed")
+	}
+
+	f.controller.addPod(newTestPeer(sw, 1))
+	if !f.controller.expectations.satisfied(swarmKey(sw)) {
+		t.Error("expected the observed recreation to satisfy expectations")
+	}
+}
+
+func TestDeletedPodTombstone(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	pod := newTestPeer(sw, 0)
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, []*corev1.Pod{pod})
+
+	f.controller.expectations.expectDeletions(swarmKey(sw), 1)
+	f.controller.deletePod(cache.DeletedFinalStateUnknown{Key: "default/foo-0", Obj: pod})
+
+	if keys := f.queued(); len(keys) != 1 || keys[0] != swarmKey(sw) {
+		t.Fatalf("expected %s queued from the tombstone, got %v", swarmKey(sw), keys)
+	}
+	if !f.controller.expectations.satisfied(swarmKey(sw)) {
+		t.Error("expected the tombstone to lower deletion expectations")
+	}
+
+	f.controller.enqueuePod(cache.DeletedFinalStateUnknown{Key: "default/foo-0", Obj: pod})
+	if keys := f.queued(); len(keys) != 1 || keys[0] != swarmKey(sw) {
+		t.Fatalf("expected %s queued from the tombstone, got %v", swarmKey(sw), keys)
+	}
+
+	// tombstones of other objects are dropped
+	f.controller.deletePod(cache.DeletedFinalStateUnknown{Key: "default/foo", Obj: sw})
+	if keys := f.queued(); len(keys) != 0 {
+		t.Errorf("expected nothing queued, got %v", keys)
+	}
+}
+
+func TestDeletedSwarmClearsState(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	sw.Spec.AddressPool = &swarmv1alpha1.AddressPool{CIDR: "10.9.9.0/24"}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+	key := swarmKey(sw)
+
+	f.controller.expectations.expectCreations(key, 2)
+	addresses, missing, err := f.controller.ipam.allocate(key, sw)
+	if err != nil || missing != 0 || len(addresses) != 2 {
+		t.Fatalf("expected two addresses allocated, got %v missing %d err %v", addresses, missing, err)
+	}
+
+	f.controller.deleteSwarm(cache.DeletedFinalStateUnknown{Key: key, Obj: sw})
+
+	if _, ok := f.controller.expectations.items[key]; ok {
+		t.Error("expected expectations of the deleted swarm dropped")
+	}
+	if len(f.controller.ipam.reserved) != 0 {
+		t.Errorf("expected address reservations released, got %v", f.controller.ipam.reserved)
+	}
+
+	// its addresses go to the next swarm
+	other := newTestSwarm("bar", 1)
+	other.Spec.AddressPool = sw.Spec.AddressPool
+	reused, _, err := f.controller.ipam.allocate(swarmKey(other), other)
+	if err != nil || len(reused) != 1 || reused[0].Address != addresses[0].Address {
+		t.Errorf("expected %s allocated again, got %v err %v", addresses[0].Address, reused, err)
+	}
+}
+
+func TestUpdateStatusRetriesConflictOnFreshRead(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+
+	// a user edits the swarm after it was cached, the first write conflicts
+	edited := sw.DeepCopy()
+	edited.Labels = map[string]string{"edited": "true"}
+	if err := f.swarmClient.Tracker().Update(swarmv1alpha1.SchemeGroupVersion.WithResource("swarms"), edited, edited.Namespace); err != nil {
+		t.Fatal(err)
+	}
+	conflicts := 0
+	f.swarmClient.PrependReactor("update", "swarms", func(action core.Action) (bool, runtime.Object, error) {
+		update := action.(core.UpdateAction)
+		if update.GetSubresource() != "status" || update.GetObject().(*swarmv1alpha1.Swarm).Labels["edited"] == "true" {
+			return false, nil, nil
+		}
+		conflicts++
+		return true, nil, errors.NewConflict(swarmv1alpha1.Resource("swarms"), sw.Name, fmt.Errorf("stale"))
+	})
+
+	status := sw.Status.DeepCopy()
+	status.Replicas = 1
+	if err := f.controller.updateStatus(context.Background(), sw, *status); err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts != 1 {
+		t.Errorf("expected one conflicting write, got %d", conflicts)
+	}
+	stored := f.refresh(sw)
+	if stored.Status.Replicas != 1 || stored.Labels["edited"] != "true" {
+		t.Errorf("expected the status written over the user edit, got replicas %d labels %v", stored.Status.Replicas, stored.Labels)
+	}
+}
+
+func TestScaleStatusFollowsPeers(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	failed := newTestPeer(sw, 1)
+	failed.Status.Phase = corev1.PodFailed
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, []*corev1.Pod{newTestPeer(sw, 0), failed})
+
+	f.sync(swarmKey(sw))
+	stored := f.refresh(sw)
+	if stored.Status.Replicas != 1 {
+		t.Errorf("expected 1 active replica on status, got %d", stored.Status.Replicas)
+	}
+	if stored.Status.Selector != swarmLabel+"=foo" {
+		t.Errorf("expected the default selector on status, got %q", stored.Status.Selector)
+	}
+
+	// scaling writes spec.replicas, as kubectl scale does through /scale
+	stored.Spec.Replicas = 3
+	f.updateSwarm(stored)
+	f.sync(swarmKey(sw))
+	if created := f.createdPods(); len(created) != 1 || created[0] != "foo-2" {
+		t.Errorf("expected foo-2 created on scale up, got %v", created)
+	}
+}
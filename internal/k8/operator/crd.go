@@ -2,6 +2,7 @@ package operator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/google/go-cmp/cmp"
 	"net"
@@ -10,27 +11,78 @@ import (
 
 	v1alpha "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
 	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
+// Pool is the consensus membership of swarms, peers are added once running
+// and removed before going away.
 type Pool interface {
-	Add(idx int, id string, add net.IP) error
+	Add(ctx context.Context, sw *v1alpha.Swarm, idx int, id string, add net.IP) error
+	Remove(ctx context.Context, sw *v1alpha.Swarm, idx int, id string) error
+	// Promote makes the learner id a voter, returning once the leader
+	// confirms it votes
+	Promote(ctx context.Context, sw *v1alpha.Swarm, idx int, id string) error
 }
 
+// errNoIndexer is returned by handler lookups before Build runs it
+var errNoIndexer = errors.New("handler has no swarm indexer, it is not built")
+
 type handler struct {
 	lastState map[string]*v1alpha.Swarm
 	mutex     sync.RWMutex
 	pool      Pool
+	recorder  record.EventRecorder
+	// indexer is the swarm informer indexer, set by Build
+	indexer cache.Indexer
+	// store keeps lastState across restarts, nil keeps it in memory only
+	store MembershipStore
+}
+
+func NewHandler(p Pool, recorder record.EventRecorder) Handler {
+	return &handler{
+		lastState: make(map[string]*v1alpha.Swarm),
+		pool:      p,
+		recorder:  recorder,
+	}
 }
 
-func NewHandler(p Pool) Handler {
+// NewDurableHandler returns a Handler keeping the last state of swarms on
+// store, swarms handled before a restart only get the changes made since
+func NewDurableHandler(p Pool, recorder record.EventRecorder, store MembershipStore) Handler {
 	return &handler{
 		lastState: make(map[string]*v1alpha.Swarm),
 		pool:      p,
+		recorder:  recorder,
+		store:     store,
+	}
+}
+
+func (h *handler) setIndexer(indexer cache.Indexer) {
+	h.indexer = indexer
+}
+
+// FindByPeerID returns the swarm of namespace holding the peer id, nil when
+// none does. It fails until Build sets the swarm indexer.
+func (h *handler) FindByPeerID(namespace, id string) (*v1alpha.Swarm, error) {
+	if h.indexer == nil {
+		return nil, errNoIndexer
 	}
+	return findByPeerID(h.indexer, namespace, id)
 }
 
-func (h *handler) Created(_ context.Context, obj runtime.Object) {
+// FindByPeerAddress returns the swarm holding the peer address, nil when
+// none does. It fails until Build sets the swarm indexer.
+func (h *handler) FindByPeerAddress(address string) (*v1alpha.Swarm, error) {
+	if h.indexer == nil {
+		return nil, errNoIndexer
+	}
+	return findByPeerAddress(h.indexer, address)
+}
+
+func (h *handler) Created(ctx context.Context, obj runtime.Object) {
 	sw := obj.(*v1alpha.Swarm)
 	log.Infof("Created CRD %s", sw.Name)
 
@@ -40,41 +92,145 @@ func (h *handler) Created(_ context.Context, obj runtime.Object) {
 		log.Errorf("Swarm creation error, %s already exists on registry", sw.Name)
 		return
 	}
-
-	for _, peer := range sw.Spec.Peers {
-		err := h.pool.Add(peer.Index, peer.ID, net.ParseIP(peer.Address))
-		if err != nil {
-			log.Errorf("error adding raft node, %v peer %v", err, peer)
-		}
+	if last := h.restore(ctx, sw); last != nil {
+		log.Infof("Swarm %s handled before restart, applying changes since", sw.Name)
+		h.update(ctx, sw, last)
+		return
 	}
 
+	added, voting := h.addPeers(ctx, sw)
+	h.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonMembershipChanged, "Membership initialized with %d of %d peers", added, len(sw.Spec.Peers))
+	h.checkQuorum(sw, voting)
+
 	h.lastState[sw.Name] = sw
+	h.persist(ctx, sw)
 }
 
-func (h *handler) Updated(_ context.Context, new runtime.Object, old runtime.Object) {
+func (h *handler) Updated(ctx context.Context, new runtime.Object, old runtime.Object) {
 	oldObj := old.(*v1alpha.Swarm)
 	newObj := new.(*v1alpha.Swarm)
 	log.Infof("Updated CRD %s", newObj.Name)
 
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.update(ctx, newObj, oldObj)
+}
+
+// update applies the changes from oldObj to newObj, the caller holds the
+// mutex
+func (h *handler) update(ctx context.Context, newObj, oldObj *v1alpha.Swarm) {
 	// @TODO: DIG ON IT!
 	report := func(raw reflect.Type) bool {
-		log.Infof("Raw DIff type %s", raw.String())
+		log.Debugf("Raw DIff type %s", raw.String())
 		return true
 	}
 	opt := cmp.Exporter(report)
-	diff := cmp.Diff(oldObj, newObj, opt)
-	fmt.Printf("%s\n", diff)
-
-	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	log.Debugf("Swarm %s changes: %s", newObj.Name, cmp.Diff(oldObj, newObj, opt))
 
 	if oldObj.Spec.Size != newObj.Spec.Size && oldObj.Spec.Size < newObj.Spec.Size { // @TODO: HAPPY PATH!
-		for _, peer := range newObj.Spec.Peers {
-			_ = h.pool.Add(peer.Index, peer.ID, net.ParseIP(peer.Address))
-		}
+		h.recorder.Eventf(newObj, corev1.EventTypeNormal, ReasonScalingStarted, "Scaling from %d to %d peers", oldObj.Spec.Size, newObj.Spec.Size)
+		added, voting := h.addPeers(ctx, newObj)
+		h.recorder.Eventf(newObj, corev1.EventTypeNormal, ReasonScalingCompleted, "Scaled to %d peers, %d registered", newObj.Spec.Size, added)
+		h.checkQuorum(newObj, voting)
+	}
+
+	if !reflect.DeepEqual(peerIDs(oldObj), peerIDs(newObj)) {
+		h.recorder.Eventf(newObj, corev1.EventTypeNormal, ReasonMembershipChanged, "Membership changed from %v to %v", peerIDs(oldObj), peerIDs(newObj))
 	}
 
 	h.lastState[newObj.Name] = newObj
+	h.persist(ctx, newObj)
+}
+
+// restore returns the last state of sw kept on store, nil when there is none
+func (h *handler) restore(ctx context.Context, sw *v1alpha.Swarm) *v1alpha.Swarm {
+	if h.store == nil {
+		return nil
+	}
+	m, err := h.store.Load(ctx, sw)
+	if err != nil {
+		log.Errorf("error loading swarm %s last state: %v", sw.Name, err)
+		return nil
+	}
+	return m.LastState
+}
+
+// persist keeps sw as last state on store, the membership is loaded again
+// as the pool may have changed it meanwhile
+func (h *handler) persist(ctx context.Context, sw *v1alpha.Swarm) {
+	if h.store == nil {
+		return
+	}
+	m, err := h.store.Load(ctx, sw)
+	if err != nil {
+		log.Errorf("error loading swarm %s membership: %v", sw.Name, err)
+		return
+	}
+	last := sw.DeepCopy()
+	last.ManagedFields = nil
+	m.LastState = last
+	if err := h.store.Save(ctx, sw, m); err != nil {
+		log.Errorf("error saving swarm %s last state: %v", sw.Name, err)
+	}
+}
+
+// addPeers registers the swarm peers on the pool, leaving out peers that
+// collide with an older swarm. It returns how many were added successfully
+// and how many of them vote.
+func (h *handler) addPeers(ctx context.Context, sw *v1alpha.Swarm) (int, int) {
+	var added, voting int
+	for _, peer := range sw.Spec.Peers {
+		if h.indexer != nil {
+			collision, err := peerCollision(h.indexer, sw.Namespace+"/"+sw.Name, sw, peer)
+			if err != nil {
+				log.Errorf("error looking up collisions of peer %s: %v", peer.ID, err)
+				continue
+			}
+			if collision != "" {
+				log.Errorf("swarm %s: %s", sw.Name, collision)
+				h.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerCollision, "Peer %s left out, %s", peer.ID, collision)
+				continue
+			}
+		}
+
+		ip := net.ParseIP(peer.Address)
+		if ip == nil {
+			log.Errorf("invalid address %q on peer %s", peer.Address, peer.ID)
+			h.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonInvalidSpec, "Peer %s has invalid address %q", peer.ID, peer.Address)
+			continue
+		}
+
+		if err := h.pool.Add(ctx, sw, peer.Index, peer.ID, ip); err != nil {
+			log.Errorf("error adding raft node, %v peer %v", err, peer)
+			h.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error adding peer %s to pool: %v", peer.ID, err)
+			continue
+		}
+		added++
+		if votingRole(desiredRole(sw, peer.Index)) {
+			voting++
+		}
+	}
+
+	return added, voting
+}
+
+// checkQuorum warns when fewer voting peers than the swarm majority are
+// registered
+func (h *handler) checkQuorum(sw *v1alpha.Swarm, registered int) {
+	if sw.Spec.Size == 0 || registered >= quorum(votingSize(sw)) {
+		return
+	}
+
+	log.Warnf("Swarm %s quorum lost, %d voting peers registered of %d required", sw.Name, registered, quorum(votingSize(sw)))
+	h.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonQuorumLost, "%d voting peers registered, quorum requires %d", registered, quorum(votingSize(sw)))
+}
+
+func peerIDs(sw *v1alpha.Swarm) []string {
+	ids := make([]string, 0, len(sw.Spec.Peers))
+	for _, peer := range sw.Spec.Peers {
+		ids = append(ids, peer.ID)
+	}
+	return ids
 }
 
 func (h *handler) Deleted(_ context.Context, obj runtime.Object) {
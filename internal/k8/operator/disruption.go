@@ -0,0 +1,92 @@
+package operator
+
+import (
+	"context"
+	"reflect"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+// newDisruptionBudget returns the budget keeping a quorum of the swarm voting
+// peers available, owned by the Swarm so it is garbage collected on teardown.
+// Learners are left out of the budget, as peers not promoted yet.
+func newDisruptionBudget(sw *swarmv1alpha1.Swarm) *policyv1.PodDisruptionBudget {
+	minAvailable := intstr.FromInt(quorum(votingSize(sw)))
+
+	selector := sw.Spec.Selector.DeepCopy()
+	if selector == nil {
+		selector = &metav1.LabelSelector{MatchLabels: map[string]string{swarmLabel: sw.Name}}
+	}
+	selector.MatchExpressions = append(selector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      peerRoleLabel,
+		Operator: metav1.LabelSelectorOpIn,
+		Values:   []string{swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleWitness},
+	})
+
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            sw.Name,
+			Namespace:       sw.Namespace,
+			Labels:          map[string]string{swarmLabel: sw.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector:     selector,
+		},
+	}
+}
+
+// reconcileDisruptionBudget creates or updates the swarm budget as its size
+// changes, or removes it when the swarm opted out.
+func (c *Controller) reconcileDisruptionBudget(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) error {
+	client := c.kubeClientset.PolicyV1().PodDisruptionBudgets(sw.Namespace)
+
+	found, err := c.pdbLister.PodDisruptionBudgets(sw.Namespace).Get(sw.Name)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && !metav1.IsControlledBy(found, sw) {
+		klog.Infof("instance %s: disruption budget %s exists but is not owned by the swarm", key, sw.Name)
+		return nil
+	}
+
+	if sw.Spec.DisableDisruptionBudget {
+		if !exists {
+			return nil
+		}
+		klog.Infof("instance %s: disruption budget disabled, deleting %s", key, found.Name)
+		err = client.Delete(ctx, found.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	pdb := newDisruptionBudget(sw)
+	if !exists {
+		klog.Infof("instance %s: disruption budget created: minAvailable=%s", key, pdb.Spec.MinAvailable.String())
+		_, err = client.Create(ctx, pdb, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+
+	if reflect.DeepEqual(found.Spec.MinAvailable, pdb.Spec.MinAvailable) && reflect.DeepEqual(found.Spec.Selector, pdb.Spec.Selector) {
+		return nil
+	}
+
+	updated := found.DeepCopy()
+	updated.Spec.MinAvailable = pdb.Spec.MinAvailable
+	updated.Spec.Selector = pdb.Spec.Selector
+	klog.Infof("instance %s: disruption budget updated: minAvailable=%s", key, pdb.Spec.MinAvailable.String())
+	_, err = client.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,57 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDisruptionBudgetFollowsSize(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	sw.Spec.Size = 5
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, []*corev1.Pod{newTestPeer(sw, 0), newTestPeer(sw, 1), newTestPeer(sw, 2)})
+
+	minAvailable := func() int {
+		t.Helper()
+		pdb, err := f.kubeClient.PolicyV1().PodDisruptionBudgets(sw.Namespace).Get(context.Background(), sw.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.kubeInformers.Policy().V1().PodDisruptionBudgets().Informer().GetIndexer().Update(pdb); err != nil {
+			t.Fatal(err)
+		}
+		return pdb.Spec.MinAvailable.IntValue()
+	}
+
+	f.sync(swarmKey(sw))
+	if got := minAvailable(); got != 3 {
+		t.Errorf("expected minAvailable 3 out of size 5, got %d", got)
+	}
+
+	sw = f.refresh(sw)
+	sw.Spec.Size = 3
+	f.updateSwarm(sw)
+	f.sync(swarmKey(sw))
+	if got := minAvailable(); got != 2 {
+		t.Errorf("expected minAvailable 2 once resized to 3, got %d", got)
+	}
+}
+
+func TestDisruptionBudgetLeavesLearnersOut(t *testing.T) {
+	sw := newTestSwarm("foo", 5)
+	sw.Spec.Learners = 2
+
+	if got := newDisruptionBudget(sw).Spec.MinAvailable.IntValue(); got != 2 {
+		t.Errorf("expected minAvailable 2 out of 3 voters, got %d", got)
+	}
+
+	// size unset falls back to replicas
+	sw.Spec.Size = 0
+	sw.Spec.Learners = 0
+	if got := newDisruptionBudget(sw).Spec.MinAvailable.IntValue(); got != 3 {
+		t.Errorf("expected minAvailable 3 out of 5 replicas, got %d", got)
+	}
+}
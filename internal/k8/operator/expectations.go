@@ -0,0 +1,117 @@
+package operator
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// expectationsTimeout is how long pending expectations block a swarm before
+// they are considered lost, i.e. a watch event was missed.
+const expectationsTimeout = time.Minute * 5
+
+// expectations records pod creations and deletions issued by the controller
+// that are not yet observed in the informer cache, keyed by swarm. While a
+// swarm has pending expectations its reconcile must not act on the cache, as
+// in the upstream ReplicaSet controller.
+type expectations struct {
+	mutex sync.Mutex
+	items map[string]*expectation
+}
+
+type expectation struct {
+	add       int
+	del       int
+	timestamp time.Time
+}
+
+func newExpectations() *expectations {
+	return &expectations{
+		items: make(map[string]*expectation),
+	}
+}
+
+// expectCreations registers n pod creations about to be issued for key
+func (e *expectations) expectCreations(key string, n int) {
+	e.expect(key, n, 0)
+}
+
+// expectDeletions registers n pod deletions about to be issued for key
+func (e *expectations) expectDeletions(key string, n int) {
+	e.expect(key, 0, n)
+}
+
+// creationObserved lowers the pending creations of key, called on pod add
+// events and when an issued creation failed.
+func (e *expectations) creationObserved(key string) {
+	e.lower(key, 1, 0)
+}
+
+// deletionObserved lowers the pending deletions of key, called on pod delete
+// events and when an issued deletion failed.
+func (e *expectations) deletionObserved(key string) {
+	e.lower(key, 0, 1)
+}
+
+// satisfied reports whether key has no pending operations, or they expired
+func (e *expectations) satisfied(key string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	exp, ok := e.items[key]
+	if !ok {
+		return true
+	}
+
+	if exp.add <= 0 && exp.del <= 0 {
+		return true
+	}
+
+	if time.Since(exp.timestamp) > expectationsTimeout {
+		klog.Warningf("expectations of %s expired, add %d del %d pending", key, exp.add, exp.del)
+		return true
+	}
+
+	return false
+}
+
+// delete forgets key expectations, called once the swarm is gone
+func (e *expectations) delete(key string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	delete(e.items, key)
+}
+
+func (e *expectations) expect(key string, add, del int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	exp, ok := e.items[key]
+	if !ok || (exp.add <= 0 && exp.del <= 0) {
+		exp = &expectation{}
+		e.items[key] = exp
+	}
+	exp.add += add
+	exp.del += del
+	exp.timestamp = time.Now()
+}
+
+// lower marks operations of key observed, counts never drop below zero as
+// the same deletion may be observed twice, from its event and a tombstone
+func (e *expectations) lower(key string, add, del int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	exp, ok := e.items[key]
+	if !ok {
+		return
+	}
+	if exp.add -= add; exp.add < 0 {
+		exp.add = 0
+	}
+	if exp.del -= del; exp.del < 0 {
+		exp.del = 0
+	}
+}
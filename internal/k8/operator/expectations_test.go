@@ -0,0 +1,63 @@
+package operator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpectationsRaiseAndLower(t *testing.T) {
+	e := newExpectations()
+	if !e.satisfied("default/foo") {
+		t.Fatal("expected a swarm without expectations to be satisfied")
+	}
+
+	e.expectCreations("default/foo", 2)
+	e.expectDeletions("default/foo", 1)
+	e.creationObserved("default/foo")
+	e.deletionObserved("default/foo")
+	if e.satisfied("default/foo") {
+		t.Fatal("expected a pending creation to block the swarm")
+	}
+	if !e.satisfied("default/bar") {
+		t.Fatal("expected expectations kept per swarm")
+	}
+
+	e.creationObserved("default/foo")
+	if !e.satisfied("default/foo") {
+		t.Fatal("expected the swarm satisfied once every operation is observed")
+	}
+
+	e.expectCreations("default/foo", 1)
+	e.delete("default/foo")
+	if !e.satisfied("default/foo") {
+		t.Fatal("expected deleted swarm expectations to be forgotten")
+	}
+}
+
+func TestExpectationsLowerClampsAtZero(t *testing.T) {
+	e := newExpectations()
+	e.expectCreations("default/foo", 1)
+	e.expectDeletions("default/foo", 1)
+	// the deletion event and its tombstone both lower the count
+	e.deletionObserved("default/foo")
+	e.deletionObserved("default/foo")
+
+	e.expectDeletions("default/foo", 1)
+	e.creationObserved("default/foo")
+	if e.satisfied("default/foo") {
+		t.Fatal("expected the deletion issued afterwards to block the swarm")
+	}
+	e.deletionObserved("default/foo")
+	if !e.satisfied("default/foo") {
+		t.Fatal("expected the swarm satisfied once every operation is observed")
+	}
+}
+
+func TestExpectationsExpire(t *testing.T) {
+	e := newExpectations()
+	e.expectCreations("default/foo", 1)
+	e.items["default/foo"].timestamp = time.Now().Add(-expectationsTimeout - time.Second)
+	if !e.satisfied("default/foo") {
+		t.Fatal("expected lost expectations to expire")
+	}
+}
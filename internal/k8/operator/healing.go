@@ -0,0 +1,234 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// peerIDAnnotation holds the peer ID a pod joins the Pool with
+	peerIDAnnotation = swarmLabel + "-peer-id"
+	// defaultUnhealthyAfter is how long a peer may stay unhealthy when the
+	// healing policy sets no threshold
+	defaultUnhealthyAfter = time.Minute * 5
+	// maxReplacementHistory bounds the replacements kept on status, the
+	// latest replacement of every peer index is always kept
+	maxReplacementHistory = 10
+)
+
+// peerID returns the ID of the peer at index, the latest replacement ID, the
+// one declared on spec peers or its pod name otherwise.
+func peerID(sw *swarmv1alpha1.Swarm, index int) string {
+	for i := len(sw.Status.Replacements) - 1; i >= 0; i-- {
+		if sw.Status.Replacements[i].Index == index {
+			return sw.Status.Replacements[i].NewID
+		}
+	}
+	for _, peer := range sw.Spec.Peers {
+		if peer.Index == index {
+			return peer.ID
+		}
+	}
+	return peerPodName(sw, index)
+}
+
+// unhealthySince returns when pod stopped being healthy, zero while it is
+func unhealthySince(pod *corev1.Pod) time.Time {
+	failed := pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded
+	if !failed && podReady(pod) {
+		return time.Time{}
+	}
+
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && !c.LastTransitionTime.IsZero() {
+			return c.LastTransitionTime.Time
+		}
+	}
+	if pod.Status.StartTime != nil {
+		return pod.Status.StartTime.Time
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// healPeers completes pending replacements and replaces the lowest index peer
+// unhealthy for longer than the healing threshold, one replacement at a time
+// and never while healthy voting peers are below quorum. It returns when the
+// next unhealthy peer is due.
+func (c *Controller) healPeers(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) (time.Duration, error) {
+	if err := c.completeReplacements(ctx, key, sw, pods); err != nil {
+		return time.Duration(0), err
+	}
+	if sw.Spec.Healing.Disabled {
+		return time.Duration(0), nil
+	}
+
+	threshold := defaultUnhealthyAfter
+	if after := sw.Spec.Healing.UnhealthyAfter; after != nil && after.Duration > 0 {
+		threshold = after.Duration
+	}
+
+	now := time.Now()
+	var victim *corev1.Pod
+	victimIdx := 0
+	var next time.Duration
+	healthy := 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			// a peer is going away, its deletion event requeues us
+			return time.Duration(0), nil
+		}
+		since := unhealthySince(pod)
+		if since.IsZero() {
+			if votingRole(pod.Labels[peerRoleLabel]) {
+				healthy++
+			}
+			continue
+		}
+		if wait := since.Add(threshold).Sub(now); wait > 0 {
+			if next == 0 || wait < next {
+				next = wait
+			}
+			continue
+		}
+		idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+		if err != nil {
+			klog.Warningf("instance %s: peer %s has invalid index label: %v", key, pod.Name, err)
+			continue
+		}
+		if victim == nil || idx < victimIdx {
+			victim, victimIdx = pod, idx
+		}
+	}
+	if victim == nil {
+		return next, nil
+	}
+
+	for _, r := range sw.Status.Replacements {
+		if r.CompletedAt == nil {
+			klog.V(4).Infof("instance %s: peer %d replacement pending, holding %s", key, r.Index, victim.Name)
+			return next, nil
+		}
+	}
+
+	size := votingSize(sw)
+	if healthy < quorum(size) {
+		klog.Warningf("instance %s: not replacing peer %s, %d healthy voting peers below quorum %d", key, victim.Name, healthy, quorum(size))
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonReplacementBlocked, "Not replacing peer pod %s, %d healthy voting peers are below quorum %d", victim.Name, healthy, quorum(size))
+		return next, nil
+	}
+
+	return next, c.replacePeer(ctx, key, sw, victim)
+}
+
+// replacePeer removes the peer of pod from the Pool, records its replacement
+// with a new peer ID and deletes the pod so that it is recreated on the same
+// index with that ID.
+func (c *Controller) replacePeer(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pod *corev1.Pod) error {
+	idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+	if err != nil {
+		return fmt.Errorf("peer %s has invalid index label: %v", pod.Name, err)
+	}
+
+	if ok, err := c.handOverLeadership(ctx, key, sw, pod); !ok || err != nil {
+		return err
+	}
+
+	oldID := pod.Annotations[peerIDAnnotation]
+	if oldID == "" {
+		oldID = peerID(sw, idx)
+	}
+	if err := c.pool.Remove(ctx, sw, idx, oldID); err != nil {
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error removing peer %s from pool: %v", oldID, err)
+		return err
+	}
+
+	reason := "NotReady"
+	if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+		reason = string(pod.Status.Phase)
+	}
+	replacement := swarmv1alpha1.PeerReplacement{
+		Index:      idx,
+		OldID:      oldID,
+		NewID:      fmt.Sprintf("%s-%s", peerPodName(sw, idx), rand.String(5)),
+		Reason:     reason,
+		ReplacedAt: metav1.Now(),
+	}
+	sw.Status.Replacements = trimReplacements(append(sw.Status.Replacements, replacement))
+
+	// the recreated pod reads its ID from status, persist it first
+	if err := c.updateStatus(ctx, sw, sw.Status); err != nil {
+		return err
+	}
+
+	klog.Infof("instance %s: replacing peer %s unhealthy since %s, id %s -> %s", key, pod.Name, unhealthySince(pod).Format(time.RFC3339), oldID, replacement.NewID)
+	c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerReplaced, "Replacing %s peer pod %s, peer %s becomes %s", reason, pod.Name, oldID, replacement.NewID)
+	c.expectations.expectDeletions(key, 1)
+	return c.deletePeer(ctx, key, sw, pod)
+}
+
+// completeReplacements adds replacement peers to the Pool once ready
+func (c *Controller) completeReplacements(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) error {
+	byIndex := make(map[string]*corev1.Pod, len(pods))
+	for _, pod := range pods {
+		byIndex[pod.Labels[peerIndexLabel]] = pod
+	}
+
+	for i := range sw.Status.Replacements {
+		r := &sw.Status.Replacements[i]
+		if r.CompletedAt != nil {
+			continue
+		}
+		pod, ok := byIndex[strconv.Itoa(r.Index)]
+		if !ok || pod.DeletionTimestamp != nil || pod.Annotations[peerIDAnnotation] != r.NewID || !podReady(pod) {
+			continue
+		}
+
+		if err := c.pool.Add(ctx, sw, r.Index, r.NewID, net.ParseIP(pod.Status.PodIP)); err != nil {
+			c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error adding replacement peer %s to pool: %v", r.NewID, err)
+			return err
+		}
+		now := metav1.Now()
+		r.CompletedAt = &now
+
+		klog.Infof("instance %s: replacement peer %s joined on pod %s", key, r.NewID, pod.Name)
+		c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonMembershipChanged, "Replacement peer %s joined on pod %s", r.NewID, pod.Name)
+	}
+
+	return nil
+}
+
+// trimReplacements drops the oldest replacements over maxReplacementHistory,
+// keeping the latest one of every index as it holds the current peer ID.
+func trimReplacements(replacements []swarmv1alpha1.PeerReplacement) []swarmv1alpha1.PeerReplacement {
+	if len(replacements) <= maxReplacementHistory {
+		return replacements
+	}
+
+	latest := map[int]bool{}
+	keep := make([]bool, len(replacements))
+	kept := 0
+	for i := len(replacements) - 1; i >= 0; i-- {
+		if !latest[replacements[i].Index] || kept < maxReplacementHistory {
+			keep[i] = true
+			kept++
+		}
+		latest[replacements[i].Index] = true
+	}
+
+	trimmed := make([]swarmv1alpha1.PeerReplacement, 0, kept)
+	for i, r := range replacements {
+		if keep[i] {
+			trimmed = append(trimmed, r)
+		}
+	}
+	return trimmed
+}
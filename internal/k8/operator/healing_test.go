@@ -0,0 +1,177 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core "k8s.io/client-go/testing"
+)
+
+// voter labels pod with the voter role its promotion sets
+func voter(pod *corev1.Pod) *corev1.Pod {
+	pod.Labels[peerRoleLabel] = swarmv1alpha1.RoleVoter
+	return pod
+}
+
+// unready marks pod not ready for the last since
+func unready(pod *corev1.Pod, since time.Duration) *corev1.Pod {
+	pod.Status.Conditions = []corev1.PodCondition{{
+		Type:               corev1.PodReady,
+		Status:             corev1.ConditionFalse,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-since)),
+	}}
+	return pod
+}
+
+// deletedPods returns the pod names deleted through the API
+func (f *fixture) deletedPods() []string {
+	var names []string
+	for _, action := range f.kubeClient.Actions() {
+		if action.GetVerb() == "delete" && action.GetResource().Resource == "pods" {
+			names = append(names, action.(core.DeleteAction).GetName())
+		}
+	}
+	return names
+}
+
+func TestHealPeersWaitsUnhealthyGracePeriod(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	sw.Spec.Healing.UnhealthyAfter = &metav1.Duration{Duration: time.Minute * 10}
+	pods := []*corev1.Pod{voter(newTestPeer(sw, 0)), unready(voter(newTestPeer(sw, 1)), time.Minute*4), voter(newTestPeer(sw, 2))}
+	f := newFixture(t, &recordingPool{}, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	next, err := f.controller.healPeers(context.Background(), swarmKey(sw), sw, pods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= time.Minute*5 || next > time.Minute*6 {
+		t.Errorf("expected foo-1 due in about 6m, got %s", next)
+	}
+	if deleted := f.deletedPods(); len(deleted) != 0 {
+		t.Fatalf("expected no peer replaced within the grace period, got %v", deleted)
+	}
+
+	unready(pods[1], time.Minute*11)
+	if _, err := f.controller.healPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := f.deletedPods(); len(deleted) != 1 || deleted[0] != "foo-1" {
+		t.Errorf("expected foo-1 replaced past the grace period, got %v", deleted)
+	}
+}
+
+func TestHealPeersReplacesLowestIndexFirst(t *testing.T) {
+	sw := newTestSwarm("foo", 12)
+	var pods []*corev1.Pod
+	for i := 0; i < 12; i++ {
+		pod := voter(newTestPeer(sw, i))
+		if i == 2 || i == 10 {
+			unready(pod, time.Hour)
+		}
+		pods = append(pods, pod)
+	}
+	// listed in name order, foo-10 before foo-2
+	pods[2], pods[10] = pods[10], pods[2]
+	f := newFixture(t, &recordingPool{}, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	if _, err := f.controller.healPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := f.deletedPods(); len(deleted) != 1 || deleted[0] != "foo-2" {
+		t.Errorf("expected foo-2 replaced first, got %v", deleted)
+	}
+}
+
+func TestHealPeersHoldsBelowQuorum(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	pods := []*corev1.Pod{voter(newTestPeer(sw, 0)), unready(voter(newTestPeer(sw, 1)), time.Hour), unready(voter(newTestPeer(sw, 2)), time.Hour)}
+	pool := &recordingPool{}
+	f := newFixture(t, pool, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	if _, err := f.controller.healPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := f.deletedPods(); len(deleted) != 0 {
+		t.Errorf("expected no peer replaced with one healthy voter out of 3, got %v", deleted)
+	}
+	if len(pool.removed) != 0 || len(sw.Status.Replacements) != 0 {
+		t.Errorf("expected membership untouched, got removed %v replacements %v", pool.removed, sw.Status.Replacements)
+	}
+	if !hasEvent(f.events(), ReasonReplacementBlocked) {
+		t.Errorf("expected a %s event", ReasonReplacementBlocked)
+	}
+}
+
+func TestHealPeersRecordsReplacement(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	pods := []*corev1.Pod{voter(newTestPeer(sw, 0)), unready(voter(newTestPeer(sw, 1)), time.Hour), voter(newTestPeer(sw, 2))}
+	pool := &recordingPool{}
+	f := newFixture(t, pool, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	if _, err := f.controller.healPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.removed) != 1 || pool.removed[0] != "foo-1" {
+		t.Fatalf("expected peer foo-1 removed from the pool, got %v", pool.removed)
+	}
+	stored, err := f.swarmClient.K8slabV1alpha1().Swarms(sw.Namespace).Get(context.Background(), sw.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacements := stored.Status.Replacements
+	if len(replacements) != 1 {
+		t.Fatalf("expected one replacement stored, got %v", replacements)
+	}
+	r := replacements[0]
+	if r.Index != 1 || r.OldID != "foo-1" || r.NewID == r.OldID || !strings.HasPrefix(r.NewID, "foo-1-") || r.CompletedAt != nil {
+		t.Errorf("expected a pending replacement of foo-1 with a new ID, got %+v", r)
+	}
+	if id := peerID(stored, 1); id != r.NewID {
+		t.Errorf("expected the recreated peer to join as %s, got %s", r.NewID, id)
+	}
+
+	// the recreated pod joins the pool once ready with the new ID
+	recreated := voter(newTestPeer(stored, 1))
+	if got := recreated.Annotations[peerIDAnnotation]; got != r.NewID {
+		t.Fatalf("expected the recreated pod annotated with %s, got %s", r.NewID, got)
+	}
+	if _, err := f.controller.healPeers(context.Background(), swarmKey(stored), stored, []*corev1.Pod{pods[0], recreated, pods[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.added) != 1 || pool.added[0] != r.NewID || stored.Status.Replacements[0].CompletedAt == nil {
+		t.Errorf("expected %s added and the replacement completed, got %v", r.NewID, pool.added)
+	}
+}
+
+func TestTrimReplacementsKeepsLatestOfEveryIndex(t *testing.T) {
+	var replacements []swarmv1alpha1.PeerReplacement
+	replacements = append(replacements, swarmv1alpha1.PeerReplacement{Index: 0, NewID: "foo-0-a"})
+	for i := 0; i < maxReplacementHistory+2; i++ {
+		replacements = append(replacements, swarmv1alpha1.PeerReplacement{Index: 1 + i%2, NewID: fmt.Sprintf("foo-%d-%d", 1+i%2, i)})
+	}
+
+	trimmed := trimReplacements(replacements)
+	if len(trimmed) != maxReplacementHistory+1 {
+		t.Fatalf("expected %d replacements kept, got %d", maxReplacementHistory+1, len(trimmed))
+	}
+	if trimmed[0].NewID != "foo-0-a" {
+		t.Errorf("expected the only replacement of index 0 kept, got %s", trimmed[0].NewID)
+	}
+	if last := trimmed[len(trimmed)-1]; last.NewID != replacements[len(replacements)-1].NewID {
+		t.Errorf("expected the latest replacement kept last, got %s", last.NewID)
+	}
+	if trimmed[1].NewID != "foo-1-2" {
+		t.Errorf("expected the oldest replacements of busy indexes dropped, got %s first", trimmed[1].NewID)
+	}
+
+	short := replacements[:3]
+	if got := trimReplacements(short); len(got) != 3 {
+		t.Errorf("expected a short history kept whole, got %v", got)
+	}
+}
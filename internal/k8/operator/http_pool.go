@@ -0,0 +1,285 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// poolRequestTimeout bounds a membership change, the leader answers once
+	// the new configuration is committed
+	poolRequestTimeout = time.Second * 15
+	// maxPoolRedirects is how many leader redirects a membership change follows
+	maxPoolRedirects = 3
+	// suffrageVoter is the suffrage of voting members on the peer status
+	suffrageVoter = "Voter"
+)
+
+// HTTPPool changes swarm membership through the admin API swarm peers serve
+// on the status endpoint port. Changes reaching a follower are redirected
+// to the leader and retried there, the resulting configuration is read back
+// from the leader before reporting success. Swarms without status endpoint
+// have no membership to manage.
+type HTTPPool struct {
+	podLister corelisters.PodLister
+	client    *http.Client
+}
+
+// NewHTTPPool returns a Pool reaching swarm peers through their pod address
+func NewHTTPPool(podLister corelisters.PodLister) *HTTPPool {
+	return &HTTPPool{
+		podLister: podLister,
+		client: &http.Client{
+			// redirects are followed by hand, writes must be sent again
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Add joins the peer id on its stable DNS name as non voting learner, the
+// pod address changes when it is recreated. Voting peers are promoted once
+// caught up. Members the swarm bootstrapped with keep their suffrage.
+func (p *HTTPPool) Add(ctx context.Context, sw *swarmv1alpha1.Swarm, idx int, id string, _ net.IP) error {
+	join := peer.JoinRequest{Member: peer.Member{ID: id, Address: peerHost(sw, idx)}}
+	return p.change(ctx, sw, peer.JoinPath, join, func(status *peer.Status) error {
+		if findServer(status, id) == nil {
+			return fmt.Errorf("peer %s missing from the swarm configuration after joining", id)
+		}
+		return nil
+	})
+}
+
+// Promote joins the learner id again as voter on the leader, succeeding once
+// the leader configuration reports it votes
+func (p *HTTPPool) Promote(ctx context.Context, sw *swarmv1alpha1.Swarm, idx int, id string) error {
+	join := peer.JoinRequest{Member: peer.Member{ID: id, Address: peerHost(sw, idx)}, Voter: true}
+	return p.change(ctx, sw, peer.JoinPath, join, func(status *peer.Status) error {
+		server := findServer(status, id)
+		if server == nil {
+			return fmt.Errorf("peer %s missing from the swarm configuration after promotion", id)
+		}
+		if server.Suffrage != suffrageVoter {
+			return fmt.Errorf("peer %s still %s after promotion", id, server.Suffrage)
+		}
+		return nil
+	})
+}
+
+// IsMember reports whether id is on the configuration of the swarm leader,
+// found through the swarm peers in turn. Swarms without status endpoint
+// have no membership to check.
+func (p *HTTPPool) IsMember(ctx context.Context, sw *swarmv1alpha1.Swarm, id string) (bool, error) {
+	endpoint := sw.Spec.Endpoints.Status
+	if endpoint == nil {
+		return true, nil
+	}
+
+	seeds, err := p.seeds(sw, endpoint.Port)
+	if err != nil {
+		return false, err
+	}
+	var errs []error
+	for _, seed := range seeds {
+		status, err := p.status(ctx, seed)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if status.State == peerStateLeader {
+			return findServer(status, id) != nil, nil
+		}
+	}
+	errs = append(errs, fmt.Errorf("swarm %s has no reachable leader", sw.Name))
+	return false, utilerrors.NewAggregate(errs)
+}
+
+// Remove makes the peer id leave the swarm
+func (p *HTTPPool) Remove(ctx context.Context, sw *swarmv1alpha1.Swarm, _ int, id string) error {
+	return p.change(ctx, sw, peer.LeavePath, peer.LeaveRequest{ID: id}, func(status *peer.Status) error {
+		if findServer(status, id) != nil {
+			return fmt.Errorf("peer %s still on the swarm configuration after leaving", id)
+		}
+		return nil
+	})
+}
+
+// change POSTs the membership change to the leader, reached through the
+// swarm peers in turn until one answers, and verifies the configuration
+// the leader reports afterwards.
+func (p *HTTPPool) change(ctx context.Context, sw *swarmv1alpha1.Swarm, path string, change interface{}, verify func(*peer.Status) error) error {
+	endpoint := sw.Spec.Endpoints.Status
+	if endpoint == nil {
+		klog.V(4).Infof("swarm %s: no status endpoint, membership change %s skipped", sw.Name, path)
+		return nil
+	}
+
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	seeds, err := p.seeds(sw, endpoint.Port)
+	if err != nil {
+		return err
+	}
+	if len(seeds) == 0 {
+		return fmt.Errorf("swarm %s has no running peer to reach the leader through", sw.Name)
+	}
+
+	var errs []error
+	for _, seed := range seeds {
+		leader, retry, err := p.post(ctx, seed, path, body)
+		if err != nil {
+			errs = append(errs, err)
+			if retry {
+				continue
+			}
+			break
+		}
+
+		status, err := p.status(ctx, leader)
+		if err != nil {
+			return fmt.Errorf("verifying membership change on %s: %v", leader, err)
+		}
+		return verify(status)
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// seeds returns the admin hosts of the swarm peers, the known leader first
+// and then by peer index
+func (p *HTTPPool) seeds(sw *swarmv1alpha1.Swarm, port int32) ([]string, error) {
+	pods, err := p.podLister.Pods(sw.Namespace).List(labels.SelectorFromSet(labels.Set{swarmLabel: sw.Name}))
+	if err != nil {
+		return nil, err
+	}
+
+	var running []*corev1.Pod
+	for _, pod := range peerPods(sw, pods) {
+		if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+			continue
+		}
+		running = append(running, pod)
+	}
+	sort.Slice(running, func(i, j int) bool {
+		if iLeader, jLeader := running[i].Name == sw.Status.Leader, running[j].Name == sw.Status.Leader; iLeader != jLeader {
+			return iLeader
+		}
+		iIdx, _ := strconv.Atoi(running[i].Labels[peerIndexLabel])
+		jIdx, _ := strconv.Atoi(running[j].Labels[peerIndexLabel])
+		return iIdx < jIdx
+	})
+
+	hosts := make([]string, 0, len(running))
+	for _, pod := range running {
+		hosts = append(hosts, net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))))
+	}
+	return hosts, nil
+}
+
+// post sends body to path on host, following leader redirects. It returns
+// the host that accepted the change, or whether another peer may be tried
+// when it failed.
+func (p *HTTPPool) post(ctx context.Context, host, path string, body []byte) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, poolRequestTimeout)
+	defer cancel()
+
+	target := url.URL{Scheme: "http", Host: host, Path: path}
+	for hop := 0; hop <= maxPoolRedirects; hop++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+		if err != nil {
+			return "", false, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return "", true, err
+		}
+		msg := readMessage(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusTemporaryRedirect || resp.StatusCode == http.StatusPermanentRedirect:
+			location, err := resp.Location()
+			if err != nil {
+				return "", true, fmt.Errorf("peer %s redirected without location: %v", target.Host, err)
+			}
+			klog.V(4).Infof("peer %s redirected %s to leader %s", target.Host, path, location.Host)
+			target = *location
+		case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+			return target.Host, false, nil
+		case resp.StatusCode == http.StatusServiceUnavailable:
+			return "", true, fmt.Errorf("peer %s answered %s: %s", target.Host, resp.Status, msg)
+		default:
+			return "", false, fmt.Errorf("peer %s answered %s: %s", target.Host, resp.Status, msg)
+		}
+	}
+
+	return "", true, fmt.Errorf("membership change %s redirected more than %d times from %s", path, maxPoolRedirects, host)
+}
+
+// status reads the consensus state of the peer on host
+func (p *HTTPPool) status(ctx context.Context, host string) (*peer.Status, error) {
+	ctx, cancel := context.WithTimeout(ctx, peerRequestTimeout)
+	defer cancel()
+
+	target := url.URL{Scheme: "http", Host: host, Path: peer.StatusPath}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s status answered %s: %s", host, resp.Status, readMessage(resp.Body))
+	}
+
+	status := &peer.Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("peer %s status decode: %v", host, err)
+	}
+	return status, nil
+}
+
+// findServer returns the member id of the status configuration, nil when
+// it is not a member
+func findServer(status *peer.Status, id string) *peer.ServerStatus {
+	for i := range status.Servers {
+		if status.Servers[i].ID == id {
+			return &status.Servers[i]
+		}
+	}
+	return nil
+}
+
+// readMessage returns the start of an error response body
+func readMessage(body io.Reader) string {
+	msg, _ := ioutil.ReadAll(io.LimitReader(body, 512))
+	return string(bytes.TrimSpace(msg))
+}
@@ -0,0 +1,275 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+)
+
+// fakeRaft is the membership of a swarm whose fakePeers joined it. Changes
+// are applied by the leader, followers redirect them to it.
+type fakeRaft struct {
+	mu      sync.Mutex
+	leader  string
+	servers []peer.ServerStatus
+	// unavailable answers that many changes with 503 per peer host
+	unavailable map[string]int
+	// dropChanges accepts changes on the leader without applying them
+	dropChanges bool
+	joins       []peer.JoinRequest
+	hits        map[string]int
+}
+
+func newFakeRaft(leader string, servers ...peer.ServerStatus) *fakeRaft {
+	return &fakeRaft{leader: leader, servers: servers, unavailable: map[string]int{}, hits: map[string]int{}}
+}
+
+// serve answers the status and membership requests of the peer on host
+func (r *fakeRaft) serve(w http.ResponseWriter, req *http.Request, host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.Method == http.MethodGet && req.URL.Path == peer.StatusPath {
+		state := "Follower"
+		if host == r.leader {
+			state = peerStateLeader
+		}
+		_ = json.NewEncoder(w).Encode(peer.Status{State: state, Term: 1, Servers: r.servers})
+		return
+	}
+
+	r.hits[host]++
+	if r.unavailable[host] > 0 {
+		r.unavailable[host]--
+		http.Error(w, "no leader elected", http.StatusServiceUnavailable)
+		return
+	}
+	if host != r.leader {
+		http.Redirect(w, req, "http://"+r.leader+req.URL.Path, http.StatusTemporaryRedirect)
+		return
+	}
+
+	switch req.URL.Path {
+	case peer.JoinPath:
+		var join peer.JoinRequest
+		if err := json.NewDecoder(req.Body).Decode(&join); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.joins = append(r.joins, join)
+		if !r.dropChanges {
+			r.join(join)
+		}
+	case peer.LeavePath:
+		var leave peer.LeaveRequest
+		if err := json.NewDecoder(req.Body).Decode(&leave); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !r.dropChanges {
+			r.leave(leave.ID)
+		}
+	default:
+		http.Error(w, "unexpected path", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// join applies the change as raft does, a voter joining as non voter keeps
+// its suffrage
+func (r *fakeRaft) join(join peer.JoinRequest) {
+	suffrage := "Nonvoter"
+	if join.Voter {
+		suffrage = suffrageVoter
+	}
+	for i := range r.servers {
+		if r.servers[i].ID != join.ID {
+			continue
+		}
+		if join.Voter {
+			r.servers[i].Suffrage = suffrage
+		}
+		r.servers[i].Address = join.Address
+		return
+	}
+	r.servers = append(r.servers, peer.ServerStatus{ID: join.ID, Address: join.Address, Suffrage: suffrage})
+}
+
+func (r *fakeRaft) leave(id string) {
+	for i := range r.servers {
+		if r.servers[i].ID == id {
+			r.servers = append(r.servers[:i], r.servers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *fakeRaft) suffrage(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, server := range r.servers {
+		if server.ID == id {
+			return server.Suffrage
+		}
+	}
+	return ""
+}
+
+// httpPoolFixture runs a HTTPPool against a three peer swarm whose peers
+// are reached on their pod address
+type httpPoolFixture struct {
+	*peerFixture
+	raft *fakeRaft
+	pool *HTTPPool
+}
+
+func newHTTPPoolFixture(t *testing.T, leader int) *httpPoolFixture {
+	f := &httpPoolFixture{
+		peerFixture: newPeerFixture(t, nil, withStatusEndpoint(newTestSwarm("foo", 3))),
+		raft:        newFakeRaft(peerHostPort(leader)),
+	}
+	for i, p := range f.peers {
+		f.raft.servers = append(f.raft.servers, peer.ServerStatus{ID: peerID(f.sw, i), Address: peerHost(f.sw, i), Suffrage: suffrageVoter})
+		p.joinRaft(f.raft, peerHostPort(i))
+	}
+
+	f.pool = NewHTTPPool(f.kubeInformers.Core().V1().Pods().Lister())
+	f.pool.client.Transport = f.fixture.peers.transport()
+	return f
+}
+
+// peerHostPort returns the admin host of the test peer at index
+func peerHostPort(index int) string {
+	return net.JoinHostPort("10.0.0."+strconv.Itoa(index+1), strconv.Itoa(testStatusPort))
+}
+
+func TestHTTPPoolAddFollowsLeaderRedirect(t *testing.T) {
+	f := newHTTPPoolFixture(t, 2)
+
+	if err := f.pool.Add(context.Background(), f.sw, 3, "foo-3", net.ParseIP("10.0.0.4")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.raft.joins) != 1 {
+		t.Fatalf("expected a single join on the leader, got %v", f.raft.joins)
+	}
+	join := f.raft.joins[0]
+	if join.ID != "foo-3" || join.Address != peerHost(f.sw, 3) || join.Voter {
+		t.Errorf("expected foo-3 joined on %s as non voter, got %+v", peerHost(f.sw, 3), join)
+	}
+	if got := f.raft.suffrage("foo-3"); got != "Nonvoter" {
+		t.Errorf("expected foo-3 non voter, got %q", got)
+	}
+	if f.raft.hits[peerHostPort(0)] != 1 {
+		t.Errorf("expected the first peer to redirect the join, got %v", f.raft.hits)
+	}
+}
+
+func TestHTTPPoolAddKeepsBootstrappedVoters(t *testing.T) {
+	f := newHTTPPoolFixture(t, 0)
+
+	if err := f.pool.Add(context.Background(), f.sw, 1, "foo-1", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := f.raft.suffrage("foo-1"); got != suffrageVoter {
+		t.Errorf("expected foo-1 to stay voter, got %q", got)
+	}
+}
+
+func TestHTTPPoolRetriesOtherPeers(t *testing.T) {
+	f := newHTTPPoolFixture(t, 2)
+	// the first peer is unreachable and the second one has no leader yet
+	f.fixture.peers.drop(peerHostPort(0))
+	f.raft.unavailable[peerHostPort(1)] = 1
+
+	if err := f.pool.Add(context.Background(), f.sw, 3, "foo-3", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.raft.hits[peerHostPort(1)] != 1 || f.raft.hits[peerHostPort(2)] != 1 {
+		t.Errorf("expected the change retried on the next peers, got %v", f.raft.hits)
+	}
+	if got := f.raft.suffrage("foo-3"); got != "Nonvoter" {
+		t.Errorf("expected foo-3 non voter, got %q", got)
+	}
+}
+
+func TestHTTPPoolFailsWithoutReachablePeer(t *testing.T) {
+	f := newHTTPPoolFixture(t, 2)
+	for i := 0; i < 3; i++ {
+		f.raft.unavailable[peerHostPort(i)] = 1
+	}
+
+	if err := f.pool.Add(context.Background(), f.sw, 3, "foo-3", nil); err == nil {
+		t.Fatal("expected an error while every peer is unavailable")
+	}
+	if got := f.raft.suffrage("foo-3"); got != "" {
+		t.Errorf("expected foo-3 not to join, got %q", got)
+	}
+}
+
+func TestHTTPPoolVerifiesMembership(t *testing.T) {
+	f := newHTTPPoolFixture(t, 0)
+	f.raft.dropChanges = true
+
+	err := f.pool.Add(context.Background(), f.sw, 3, "foo-3", nil)
+	if err == nil || !strings.Contains(err.Error(), "missing from the swarm configuration") {
+		t.Errorf("expected the join verified against the leader, got %v", err)
+	}
+	err = f.pool.Promote(context.Background(), f.sw, 3, "foo-3")
+	if err == nil {
+		t.Error("expected the promotion verified against the leader")
+	}
+	err = f.pool.Remove(context.Background(), f.sw, 1, "foo-1")
+	if err == nil || !strings.Contains(err.Error(), "still on the swarm configuration") {
+		t.Errorf("expected the leave verified against the leader, got %v", err)
+	}
+}
+
+func TestHTTPPoolPromoteAndRemove(t *testing.T) {
+	f := newHTTPPoolFixture(t, 1)
+	ctx := context.Background()
+
+	if err := f.pool.Add(ctx, f.sw, 3, "foo-3", nil); err != nil {
+		t.Fatalf("unexpected add error: %v", err)
+	}
+	if err := f.pool.Promote(ctx, f.sw, 3, "foo-3"); err != nil {
+		t.Fatalf("unexpected promote error: %v", err)
+	}
+	if got := f.raft.suffrage("foo-3"); got != suffrageVoter {
+		t.Errorf("expected foo-3 promoted, got %q", got)
+	}
+	if last := f.raft.joins[len(f.raft.joins)-1]; !last.Voter || last.Address != peerHost(f.sw, 3) {
+		t.Errorf("expected a voter join on %s, got %+v", peerHost(f.sw, 3), last)
+	}
+
+	if err := f.pool.Remove(ctx, f.sw, 3, "foo-3"); err != nil {
+		t.Fatalf("unexpected remove error: %v", err)
+	}
+	if got := f.raft.suffrage("foo-3"); got != "" {
+		t.Errorf("expected foo-3 removed, got %q", got)
+	}
+}
+
+func TestHTTPPoolIsMember(t *testing.T) {
+	f := newHTTPPoolFixture(t, 1)
+	ctx := context.Background()
+
+	if member, err := f.pool.IsMember(ctx, f.sw, "foo-0"); err != nil || !member {
+		t.Errorf("expected foo-0 member, got %v err %v", member, err)
+	}
+	if member, err := f.pool.IsMember(ctx, f.sw, "foo-9"); err != nil || member {
+		t.Errorf("expected foo-9 not member, got %v err %v", member, err)
+	}
+
+	f.fixture.peers.drop(peerHostPort(1))
+	if _, err := f.pool.IsMember(ctx, f.sw, "foo-0"); err == nil {
+		t.Error("expected an error without a reachable leader")
+	}
+}
@@ -0,0 +1,297 @@
+package operator
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// peerIDIndex indexes swarms by namespace and the peer IDs they hold,
+	// <namespace>/<id>, as IDs default to pod names
+	peerIDIndex = "peerID"
+	// addressIndex indexes swarms by the peer addresses they hold
+	addressIndex = "address"
+	// selectorIndex indexes swarms by namespace and the label pairs their
+	// selector requires, <namespace>/<label>=<value>
+	selectorIndex = "selector"
+	// anyLabel is the selector index value of swarms whose selector requires
+	// no label pair, they are candidates for every pod of their namespace
+	anyLabel = "*"
+)
+
+// SwarmIndexers returns the swarm informer indexers by peer ID, peer address
+// and selector labels
+func SwarmIndexers() cache.Indexers {
+	return cache.Indexers{
+		peerIDIndex:   peerIDIndexFunc,
+		addressIndex:  addressIndexFunc,
+		selectorIndex: selectorIndexFunc,
+	}
+}
+
+// indexersFor returns the indexers of informers on obj kind
+func indexersFor(obj runtime.Object) cache.Indexers {
+	if _, ok := obj.(*swarmv1alpha1.Swarm); ok {
+		return SwarmIndexers()
+	}
+	return cache.Indexers{}
+}
+
+// swarmPeerIDs returns every peer ID sw holds: its peers below replicas and
+// the ones declared on spec peers
+func swarmPeerIDs(sw *swarmv1alpha1.Swarm) []string {
+	ids := map[string]bool{}
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		ids[peerID(sw, i)] = true
+	}
+	for _, peer := range sw.Spec.Peers {
+		if peer.ID != "" {
+			ids[peer.ID] = true
+		}
+	}
+
+	list := make([]string, 0, len(ids))
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// swarmAddresses returns every peer address sw holds, allocated from its
+// pool or declared on spec peers, in canonical form
+func swarmAddresses(sw *swarmv1alpha1.Swarm) []string {
+	var addresses []string
+	for _, a := range sw.Status.Addresses {
+		if ip := net.ParseIP(a.Address); ip != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	for _, peer := range sw.Spec.Peers {
+		if ip := net.ParseIP(peer.Address); ip != nil {
+			addresses = append(addresses, ip.String())
+		}
+	}
+	return addresses
+}
+
+func peerIDIndexFunc(obj interface{}) ([]string, error) {
+	sw, ok := obj.(*swarmv1alpha1.Swarm)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T on swarm index", obj)
+	}
+	ids := swarmPeerIDs(sw)
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, peerIDIndexKey(sw.Namespace, id))
+	}
+	return values, nil
+}
+
+func addressIndexFunc(obj interface{}) ([]string, error) {
+	sw, ok := obj.(*swarmv1alpha1.Swarm)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T on swarm index", obj)
+	}
+	return swarmAddresses(sw), nil
+}
+
+func selectorIndexFunc(obj interface{}) ([]string, error) {
+	sw, ok := obj.(*swarmv1alpha1.Swarm)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T on swarm index", obj)
+	}
+
+	matchLabels := map[string]string{swarmLabel: sw.Name}
+	if sw.Spec.Selector != nil {
+		matchLabels = sw.Spec.Selector.MatchLabels
+	}
+	if len(matchLabels) == 0 {
+		return []string{selectorIndexKey(sw.Namespace, anyLabel, "")}, nil
+	}
+
+	values := make([]string, 0, len(matchLabels))
+	for k, v := range matchLabels {
+		values = append(values, selectorIndexKey(sw.Namespace, k, v))
+	}
+	return values, nil
+}
+
+func peerIDIndexKey(namespace, id string) string {
+	return namespace + "/" + id
+}
+
+func selectorIndexKey(namespace, label, value string) string {
+	if label == anyLabel {
+		return namespace + "/" + anyLabel
+	}
+	return namespace + "/" + label + "=" + value
+}
+
+// swarmsByIndex returns the swarms indexed under value, skipping the swarm
+// named by key
+func swarmsByIndex(indexer cache.Indexer, index, value, key string) ([]*swarmv1alpha1.Swarm, error) {
+	objs, err := indexer.ByIndex(index, value)
+	if err != nil {
+		return nil, err
+	}
+
+	var swarms []*swarmv1alpha1.Swarm
+	for _, obj := range objs {
+		sw, ok := obj.(*swarmv1alpha1.Swarm)
+		if !ok {
+			continue
+		}
+		if k, err := cache.MetaNamespaceKeyFunc(sw); err == nil && k == key {
+			continue
+		}
+		swarms = append(swarms, sw)
+	}
+	return swarms, nil
+}
+
+// FindByPeerID returns the swarm of namespace holding the peer id, nil when
+// none does. The oldest swarm keeps colliding peers.
+func (c *Controller) FindByPeerID(namespace, id string) (*swarmv1alpha1.Swarm, error) {
+	return findByPeerID(c.swarmIndexer, namespace, id)
+}
+
+// FindByPeerAddress returns the swarm holding the peer address, nil when
+// none does. The oldest swarm keeps colliding peers.
+func (c *Controller) FindByPeerAddress(address string) (*swarmv1alpha1.Swarm, error) {
+	return findByPeerAddress(c.swarmIndexer, address)
+}
+
+// findByPeerID returns the oldest swarm of namespace holding the peer id,
+// nil when none does
+func findByPeerID(indexer cache.Indexer, namespace, id string) (*swarmv1alpha1.Swarm, error) {
+	swarms, err := swarmsByIndex(indexer, peerIDIndex, peerIDIndexKey(namespace, id), "")
+	if err != nil {
+		return nil, err
+	}
+	return oldestSwarm(swarms), nil
+}
+
+// findByPeerAddress returns the oldest swarm holding the peer address, nil
+// when none does
+func findByPeerAddress(indexer cache.Indexer, address string) (*swarmv1alpha1.Swarm, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid peer address %q", address)
+	}
+	swarms, err := swarmsByIndex(indexer, addressIndex, ip.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	return oldestSwarm(swarms), nil
+}
+
+// oldestSwarm returns the swarm created first, by key on the same time, nil
+// when there is none
+func oldestSwarm(swarms []*swarmv1alpha1.Swarm) *swarmv1alpha1.Swarm {
+	var oldest *swarmv1alpha1.Swarm
+	for _, sw := range swarms {
+		if oldest == nil || sw.CreationTimestamp.Before(&oldest.CreationTimestamp) ||
+			sw.CreationTimestamp.Equal(&oldest.CreationTimestamp) && sw.Namespace+"/"+sw.Name < oldest.Namespace+"/"+oldest.Name {
+			oldest = sw
+		}
+	}
+	return oldest
+}
+
+// swarmsForPod returns the swarms of the pod namespace whose selector
+// matches the pod labels
+func swarmsForPod(indexer cache.Indexer, pod *corev1.Pod) ([]*swarmv1alpha1.Swarm, error) {
+	values := []string{selectorIndexKey(pod.Namespace, anyLabel, "")}
+	for k, v := range pod.Labels {
+		values = append(values, selectorIndexKey(pod.Namespace, k, v))
+	}
+
+	seen := map[string]bool{}
+	var swarms []*swarmv1alpha1.Swarm
+	for _, value := range values {
+		candidates, err := swarmsByIndex(indexer, selectorIndex, value, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, sw := range candidates {
+			if seen[sw.Name] {
+				continue
+			}
+			seen[sw.Name] = true
+
+			selector, err := swarmSelector(sw)
+			if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			swarms = append(swarms, sw)
+		}
+	}
+	return swarms, nil
+}
+
+// peerCollisions returns the spec peers of sw whose ID a swarm of its
+// namespace, or whose address any swarm, created before it holds already.
+// The oldest swarm keeps colliding peers.
+func peerCollisions(indexer cache.Indexer, key string, sw *swarmv1alpha1.Swarm) ([]string, error) {
+	var collisions []string
+	for _, peer := range sw.Spec.Peers {
+		collision, err := peerCollision(indexer, key, sw, peer)
+		if err != nil {
+			return nil, err
+		}
+		if collision != "" {
+			collisions = append(collisions, collision)
+		}
+	}
+	return collisions, nil
+}
+
+// peerCollision describes how the spec peer of sw collides with a swarm
+// created before it, empty when it does not
+func peerCollision(indexer cache.Indexer, key string, sw *swarmv1alpha1.Swarm, peer swarmv1alpha1.Peer) (string, error) {
+	if peer.ID != "" {
+		holder, err := firstHolder(indexer, peerIDIndex, peerIDIndexKey(sw.Namespace, peer.ID), key, sw)
+		if err != nil {
+			return "", err
+		}
+		if holder != nil {
+			return fmt.Sprintf("peer ID %s held by swarm %s/%s", peer.ID, holder.Namespace, holder.Name), nil
+		}
+	}
+	if ip := net.ParseIP(peer.Address); ip != nil {
+		holder, err := firstHolder(indexer, addressIndex, ip.String(), key, sw)
+		if err != nil {
+			return "", err
+		}
+		if holder != nil {
+			return fmt.Sprintf("peer address %s held by swarm %s/%s", ip, holder.Namespace, holder.Name), nil
+		}
+	}
+	return "", nil
+}
+
+// firstHolder returns a swarm indexed under value created before sw, the
+// swarm named by key excluded
+func firstHolder(indexer cache.Indexer, index, value, key string, sw *swarmv1alpha1.Swarm) (*swarmv1alpha1.Swarm, error) {
+	holders, err := swarmsByIndex(indexer, index, value, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, holder := range holders {
+		if holder.CreationTimestamp.Before(&sw.CreationTimestamp) {
+			return holder, nil
+		}
+		if holder.CreationTimestamp.Equal(&sw.CreationTimestamp) && holder.Namespace+"/"+holder.Name < key {
+			return holder, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,167 @@
+package operator
+
+import (
+	"testing"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// peeredSwarm returns a swarm declaring peer id on address, created at
+// second offset after the test swarms
+func peeredSwarm(name string, offset int64, id, address string) *swarmv1alpha1.Swarm {
+	sw := newTestSwarm(name, 1)
+	sw.CreationTimestamp = metav1.NewTime(time.Unix(1600000000+offset, 0))
+	sw.Spec.Peers = []swarmv1alpha1.Peer{{ID: id, Index: 0, Address: address}}
+	return sw
+}
+
+func TestFindByPeerID(t *testing.T) {
+	foo := peeredSwarm("foo", 0, "a", "10.1.0.1")
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{foo}, nil)
+
+	found, err := f.controller.FindByPeerID(metav1.NamespaceDefault, "a")
+	if err != nil || found == nil || found.Name != "foo" {
+		t.Fatalf("expected foo holding peer a, got %v err %v", found, err)
+	}
+	// peers below replicas are indexed by their pod name when not declared
+	bar := newTestSwarm("bar", 2)
+	f.addSwarm(bar)
+	if found, _ := f.controller.FindByPeerID(metav1.NamespaceDefault, "bar-1"); found == nil || found.Name != "bar" {
+		t.Errorf("expected bar holding peer bar-1, got %v", found)
+	}
+	if found, err := f.controller.FindByPeerID(metav1.NamespaceDefault, "unknown"); err != nil || found != nil {
+		t.Errorf("expected no swarm for an unknown peer, got %v err %v", found, err)
+	}
+}
+
+func TestFindByPeerAddress(t *testing.T) {
+	foo := peeredSwarm("foo", 0, "a", "fd00::1")
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{foo}, nil)
+
+	// addresses are looked up in canonical form
+	found, err := f.controller.FindByPeerAddress("fd00:0:0:0::1")
+	if err != nil || found == nil || found.Name != "foo" {
+		t.Fatalf("expected foo holding fd00::1, got %v err %v", found, err)
+	}
+	if _, err := f.controller.FindByPeerAddress("not-an-ip"); err == nil {
+		t.Error("expected an error on an invalid address")
+	}
+	if found, err := f.controller.FindByPeerAddress("10.9.9.9"); err != nil || found != nil {
+		t.Errorf("expected no swarm for an unknown address, got %v err %v", found, err)
+	}
+}
+
+func TestFindByPeerCollisionOldestWins(t *testing.T) {
+	newer := peeredSwarm("a-newer", 10, "a", "10.1.0.1")
+	older := peeredSwarm("z-older", 0, "a", "10.1.0.1")
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{newer, older}, nil)
+
+	if found, _ := f.controller.FindByPeerID(metav1.NamespaceDefault, "a"); found == nil || found.Name != "z-older" {
+		t.Errorf("expected the oldest swarm holding peer a, got %v", found)
+	}
+	if found, _ := f.controller.FindByPeerAddress("10.1.0.1"); found == nil || found.Name != "z-older" {
+		t.Errorf("expected the oldest swarm holding 10.1.0.1, got %v", found)
+	}
+
+	collisions, err := peerCollisions(f.controller.swarmIndexer, swarmKey(newer), newer)
+	if err != nil || len(collisions) != 1 {
+		t.Fatalf("expected the newer swarm peer to collide, got %v err %v", collisions, err)
+	}
+	if collisions, _ := peerCollisions(f.controller.swarmIndexer, swarmKey(older), older); len(collisions) != 0 {
+		t.Errorf("expected the older swarm to keep its peers, got %v", collisions)
+	}
+
+	// created on the same second the key breaks the tie
+	tied := peeredSwarm("b-tied", 0, "a", "10.1.0.1")
+	f.addSwarm(tied)
+	if found, _ := f.controller.FindByPeerID(metav1.NamespaceDefault, "a"); found == nil || found.Name != "b-tied" {
+		t.Errorf("expected b-tied holding peer a on the tie, got %v", found)
+	}
+}
+
+func TestPeerIDsScopedByNamespace(t *testing.T) {
+	foo := newTestSwarm("foo", 2)
+	foo.Spec.Peers = []swarmv1alpha1.Peer{{Index: 0, ID: "foo-0"}}
+	other := foo.DeepCopy()
+	other.Namespace = "other"
+	other.CreationTimestamp = metav1.NewTime(foo.CreationTimestamp.Add(-time.Hour))
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{foo, other}, nil)
+
+	// both swarms default their peer IDs to the same pod names
+	for _, sw := range []*swarmv1alpha1.Swarm{foo, other} {
+		found, err := f.controller.FindByPeerID(sw.Namespace, "foo-1")
+		if err != nil || found == nil || found.Namespace != sw.Namespace {
+			t.Errorf("expected foo of %s holding foo-1, got %v err %v", sw.Namespace, found, err)
+		}
+	}
+	if collisions, err := peerCollisions(f.controller.swarmIndexer, swarmKey(foo), foo); err != nil || len(collisions) != 0 {
+		t.Errorf("expected no collision across namespaces, got %v err %v", collisions, err)
+	}
+	if found, _ := f.controller.FindByPeerID("missing", "foo-1"); found != nil {
+		t.Errorf("expected no swarm holding foo-1 in missing, got %v", found)
+	}
+}
+
+func TestHandlerFindsPeers(t *testing.T) {
+	foo := peeredSwarm("foo", 0, "a", "10.1.0.1")
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{foo}, nil)
+	h := NewHandler(&recordingPool{}, f.recorder)
+	finder, ok := h.(PeerFinder)
+	if !ok {
+		t.Fatal("expected the handler to find peers")
+	}
+	if _, err := finder.FindByPeerID(foo.Namespace, "a"); err == nil {
+		t.Error("expected lookups to fail before Build sets the indexer")
+	}
+
+	h.(indexedHandler).setIndexer(f.controller.swarmIndexer)
+	if found, err := finder.FindByPeerID(foo.Namespace, "a"); err != nil || found == nil || found.Name != "foo" {
+		t.Errorf("expected foo holding peer a, got %v err %v", found, err)
+	}
+	if found, err := finder.FindByPeerAddress("10.1.0.1"); err != nil || found == nil || found.Name != "foo" {
+		t.Errorf("expected foo holding 10.1.0.1, got %v err %v", found, err)
+	}
+}
+
+func TestSwarmsForPod(t *testing.T) {
+	foo := newTestSwarm("foo", 1)
+	selected := newTestSwarm("selected", 1)
+	selected.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "tier": "data"}}
+	everything := newTestSwarm("everything", 1)
+	everything.Spec.Selector = &metav1.LabelSelector{}
+	other := newTestSwarm("other", 1)
+	other.Namespace = "other"
+	other.Spec.Selector = &metav1.LabelSelector{}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{foo, selected, everything, other}, nil)
+
+	names := func(pod *corev1.Pod) map[string]bool {
+		t.Helper()
+		swarms, err := swarmsForPod(f.controller.swarmIndexer, pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := map[string]bool{}
+		for _, sw := range swarms {
+			found[sw.Name] = true
+		}
+		return found
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Labels: map[string]string{swarmLabel: "foo"}}}
+	if found := names(pod); len(found) != 2 || !found["foo"] || !found["everything"] {
+		t.Errorf("expected foo and everything, got %v", found)
+	}
+
+	// every label the selector requires must match
+	pod.Labels = map[string]string{"app": "db"}
+	if found := names(pod); len(found) != 1 || !found["everything"] {
+		t.Errorf("expected everything only, got %v", found)
+	}
+	pod.Labels["tier"] = "data"
+	if found := names(pod); len(found) != 2 || !found["selected"] || !found["everything"] {
+		t.Errorf("expected selected and everything, got %v", found)
+	}
+}
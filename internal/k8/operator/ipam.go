@@ -0,0 +1,319 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// peerAddressAnnotation holds the address allocated to a peer pod
+	peerAddressAnnotation = swarmLabel + "-peer-address"
+	// addressRetryPeriod is how often allocation is retried on an exhausted
+	// pool, releases on other swarms do not requeue the swarm
+	addressRetryPeriod = time.Second * 30
+)
+
+// peerAddress returns the address allocated to the peer at index, empty
+// when it has none
+func peerAddress(sw *swarmv1alpha1.Swarm, index int) string {
+	for _, a := range sw.Status.Addresses {
+		if a.Index == index {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+// ipRange is an inclusive range of addresses in 16 byte form
+type ipRange struct {
+	first, last net.IP
+}
+
+func (r ipRange) contains(ip net.IP) bool {
+	return bytes.Compare(ip, r.first) >= 0 && bytes.Compare(ip, r.last) <= 0
+}
+
+// addressPool is a parsed AddressPool
+type addressPool struct {
+	network *net.IPNet
+	exclude []ipRange
+}
+
+// parseAddressPool validates pool, exclusions must be of the pool family
+func parseAddressPool(pool *swarmv1alpha1.AddressPool) (*addressPool, error) {
+	_, network, err := net.ParseCIDR(pool.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pool cidr: %v", err)
+	}
+
+	p := &addressPool{network: network}
+	ipv4 := network.IP.To4() != nil
+	for _, entry := range pool.Exclude {
+		r, err := parseRange(entry)
+		if err != nil {
+			return nil, err
+		}
+		if (r.first.To4() != nil) != ipv4 || (r.last.To4() != nil) != ipv4 {
+			return nil, fmt.Errorf("excluded %q is not of the address pool family", entry)
+		}
+		p.exclude = append(p.exclude, r)
+	}
+	return p, nil
+}
+
+// parseRange parses an address, a CIDR or a first-last address range
+func parseRange(entry string) (ipRange, error) {
+	entry = strings.TrimSpace(entry)
+	switch {
+	case strings.Contains(entry, "/"):
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid excluded cidr %q: %v", entry, err)
+		}
+		return ipRange{first: network.IP.To16(), last: lastAddress(network)}, nil
+	case strings.Contains(entry, "-"):
+		parts := strings.SplitN(entry, "-", 2)
+		first, last := net.ParseIP(strings.TrimSpace(parts[0])), net.ParseIP(strings.TrimSpace(parts[1]))
+		if first == nil || last == nil || bytes.Compare(first.To16(), last.To16()) > 0 {
+			return ipRange{}, fmt.Errorf("invalid excluded range %q", entry)
+		}
+		return ipRange{first: first.To16(), last: last.To16()}, nil
+	default:
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return ipRange{}, fmt.Errorf("invalid excluded address %q", entry)
+		}
+		return ipRange{first: ip.To16(), last: ip.To16()}, nil
+	}
+}
+
+// lastAddress returns the highest address of network in 16 byte form
+func lastAddress(network *net.IPNet) net.IP {
+	ip := network.IP.To16()
+	mask := network.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+	}
+	last := make(net.IP, net.IPv6len)
+	for i := range ip {
+		last[i] = ip[i] | ^mask[i]
+	}
+	return last
+}
+
+// allocatable reports whether ip may be handed to a peer: inside the
+// network, not excluded, and neither the network address nor the IPv4
+// broadcast address of networks large enough to have them
+func (p *addressPool) allocatable(ip net.IP) bool {
+	if !p.network.Contains(ip) {
+		return false
+	}
+	ones, bits := p.network.Mask.Size()
+	if bits-ones > 1 {
+		if ip.Equal(p.network.IP) {
+			return false
+		}
+		if bits == 8*net.IPv4len && ip.Equal(lastAddress(p.network)) {
+			return false
+		}
+	}
+	return p.excluded(ip) == nil
+}
+
+// excluded returns the excluded range holding ip, nil when there is none
+func (p *addressPool) excluded(ip net.IP) *ipRange {
+	for i := range p.exclude {
+		if p.exclude[i].contains(ip.To16()) {
+			return &p.exclude[i]
+		}
+	}
+	return nil
+}
+
+// nextAddress returns the address following ip
+func nextAddress(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// ipam allocates peer addresses from swarm address pools. Addresses held
+// by other swarms are found through the swarm cache address index, the
+// ones allocated and not yet observed there are held as reservations.
+type ipam struct {
+	indexer cache.Indexer
+
+	mutex sync.Mutex
+	// reserved maps the addresses allocated by this controller to the key
+	// of the swarm holding them
+	reserved map[string]string
+}
+
+func newIPAM(indexer cache.Indexer) *ipam {
+	return &ipam{indexer: indexer, reserved: map[string]string{}}
+}
+
+// allocate returns the addresses of the swarm peers below replicas, keeping
+// the ones already allocated and still in the pool, allocating the missing
+// ones and releasing the rest. Peers declaring an address on spec peers get
+// none. It also returns how many peers were left without address because
+// the pool is exhausted.
+func (a *ipam) allocate(key string, sw *swarmv1alpha1.Swarm) ([]swarmv1alpha1.PeerAddress, int, error) {
+	if sw.Spec.AddressPool == nil {
+		a.release(key)
+		return nil, 0, nil
+	}
+	pool, err := parseAddressPool(sw.Spec.AddressPool)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	declared := map[int]bool{}
+	used := map[string]bool{}
+	for _, peer := range sw.Spec.Peers {
+		if ip := net.ParseIP(peer.Address); ip != nil {
+			declared[peer.Index] = true
+			used[ip.String()] = true
+		}
+	}
+
+	allocated := map[int]string{}
+	for _, addr := range sw.Status.Addresses {
+		ip := net.ParseIP(addr.Address)
+		if addr.Index >= sw.Spec.Replicas || declared[addr.Index] || ip == nil || !pool.allocatable(ip) || used[ip.String()] {
+			continue
+		}
+		allocated[addr.Index] = ip.String()
+		used[ip.String()] = true
+	}
+
+	var missing int
+	next := pool.network.IP
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		if declared[i] {
+			continue
+		}
+		if _, ok := allocated[i]; ok {
+			continue
+		}
+		if missing > 0 {
+			missing++
+			continue
+		}
+
+		ip, err := a.free(key, pool, next, used)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ip == nil {
+			missing++
+			continue
+		}
+		allocated[i] = ip.String()
+		used[ip.String()] = true
+		next = nextAddress(ip)
+	}
+
+	// reservations not allocated anymore are released
+	for addr, owner := range a.reserved {
+		if owner == key && !used[addr] {
+			delete(a.reserved, addr)
+		}
+	}
+
+	addresses := make([]swarmv1alpha1.PeerAddress, 0, len(allocated))
+	for index, addr := range allocated {
+		a.reserved[addr] = key
+		addresses = append(addresses, swarmv1alpha1.PeerAddress{Index: index, Address: addr})
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].Index < addresses[j].Index
+	})
+	return addresses, missing, nil
+}
+
+// free returns the first allocatable address from start held by no swarm,
+// nil when the pool is exhausted. Every address skipped outside excluded
+// ranges is the network or broadcast address, used, reserved or held by
+// another swarm, so the scan stops after that many.
+func (a *ipam) free(key string, pool *addressPool, start net.IP, used map[string]bool) (net.IP, error) {
+	limit := len(used) + len(a.reserved) + len(a.indexer.ListIndexFuncValues(addressIndex)) + 2
+	for ip := start; pool.network.Contains(ip) && limit >= 0; ip = nextAddress(ip) {
+		if r := pool.excluded(ip); r != nil {
+			// excluded ranges are skipped at once, they may be large on IPv6
+			ip = r.last
+			continue
+		}
+		limit--
+		if !pool.allocatable(ip) || used[ip.String()] {
+			continue
+		}
+		if owner, ok := a.reserved[ip.String()]; ok && owner != key {
+			continue
+		}
+		holders, err := swarmsByIndex(a.indexer, addressIndex, ip.String(), key)
+		if err != nil {
+			return nil, err
+		}
+		if len(holders) > 0 {
+			continue
+		}
+		return ip, nil
+	}
+	return nil, nil
+}
+
+// release drops the reservations of the swarm key
+func (a *ipam) release(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for addr, owner := range a.reserved {
+		if owner == key {
+			delete(a.reserved, addr)
+		}
+	}
+}
+
+// annotateAddresses annotates the peer pods with their allocated address
+func (c *Controller) annotateAddresses(ctx context.Context, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) error {
+	for _, pod := range pods {
+		idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+		if err != nil {
+			continue
+		}
+		if err := c.annotatePod(ctx, pod, peerAddressAnnotation, peerAddress(sw, idx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAddressPool checks the swarm address pool parses
+func validateAddressPool(sw *swarmv1alpha1.Swarm) error {
+	if sw.Spec.AddressPool == nil {
+		return nil
+	}
+	_, err := parseAddressPool(sw.Spec.AddressPool)
+	return err
+}
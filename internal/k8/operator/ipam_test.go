@@ -0,0 +1,218 @@
+package operator
+
+import (
+	"net"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		entry       string
+		first, last string
+		fails       bool
+	}{
+		{name: "cidr", entry: "10.9.9.8/30", first: "10.9.9.8", last: "10.9.9.11"},
+		{name: "ipv6 cidr", entry: "fd00::10/124", first: "fd00::10", last: "fd00::1f"},
+		{name: "range", entry: "10.9.9.20 - 10.9.9.29", first: "10.9.9.20", last: "10.9.9.29"},
+		{name: "single address", entry: " 10.9.9.7 ", first: "10.9.9.7", last: "10.9.9.7"},
+		{name: "reversed range", entry: "10.9.9.29-10.9.9.20", fails: true},
+		{name: "invalid cidr", entry: "10.9.9.0/33", fails: true},
+		{name: "invalid address", entry: "peer-0", fails: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRange(tt.entry)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("expected %q rejected, got %v-%v", tt.entry, r.first, r.last)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.first.Equal(net.ParseIP(tt.first)) || !r.last.Equal(net.ParseIP(tt.last)) {
+				t.Errorf("expected %s-%s, got %v-%v", tt.first, tt.last, r.first, r.last)
+			}
+		})
+	}
+}
+
+func TestParseAddressPool(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		pool  swarmv1alpha1.AddressPool
+		fails bool
+	}{
+		{name: "ipv4", pool: swarmv1alpha1.AddressPool{CIDR: "10.9.9.0/24", Exclude: []string{"10.9.9.1", "10.9.9.64/26"}}},
+		{name: "ipv6", pool: swarmv1alpha1.AddressPool{CIDR: "fd00::/120", Exclude: []string{"fd00::1-fd00::9"}}},
+		{name: "invalid cidr", pool: swarmv1alpha1.AddressPool{CIDR: "10.9.9.0"}, fails: true},
+		{name: "ipv6 excluded from ipv4", pool: swarmv1alpha1.AddressPool{CIDR: "10.9.9.0/24", Exclude: []string{"fd00::1"}}, fails: true},
+		{name: "ipv4 excluded from ipv6", pool: swarmv1alpha1.AddressPool{CIDR: "fd00::/120", Exclude: []string{"10.9.9.0/28"}}, fails: true},
+		{name: "mixed range", pool: swarmv1alpha1.AddressPool{CIDR: "10.9.9.0/24", Exclude: []string{"10.9.9.1-fd00::1"}}, fails: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAddressPool(&tt.pool)
+			if failed := err != nil; failed != tt.fails {
+				t.Errorf("expected failure %v, got %v", tt.fails, err)
+			}
+		})
+	}
+}
+
+// pooledSwarm returns a swarm of replicas allocating from cidr
+func pooledSwarm(name string, replicas int, cidr string, exclude ...string) *swarmv1alpha1.Swarm {
+	sw := newTestSwarm(name, replicas)
+	sw.Spec.AddressPool = &swarmv1alpha1.AddressPool{CIDR: cidr, Exclude: exclude}
+	return sw
+}
+
+func TestIPAMAllocate(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		sw        *swarmv1alpha1.Swarm
+		holders   []*swarmv1alpha1.Swarm
+		addresses []string
+		missing   int
+	}{
+		{
+			name:      "ipv4 skips the network address",
+			sw:        pooledSwarm("foo", 3, "10.9.9.0/24"),
+			addresses: []string{"10.9.9.1", "10.9.9.2", "10.9.9.3"},
+		},
+		{
+			name:      "ipv4 skips the broadcast address",
+			sw:        pooledSwarm("foo", 3, "10.9.9.0/30"),
+			addresses: []string{"10.9.9.1", "10.9.9.2"},
+			missing:   1,
+		},
+		{
+			name:      "ipv4 point to point keeps both addresses",
+			sw:        pooledSwarm("foo", 2, "10.9.9.0/31"),
+			addresses: []string{"10.9.9.0", "10.9.9.1"},
+		},
+		{
+			name:      "ipv4 exclusions",
+			sw:        pooledSwarm("foo", 3, "10.9.9.0/24", "10.9.9.1", "10.9.9.3-10.9.9.10"),
+			addresses: []string{"10.9.9.2", "10.9.9.11", "10.9.9.12"},
+		},
+		{
+			name:      "ipv6 keeps the last address",
+			sw:        pooledSwarm("foo", 3, "fd00::fc/126"),
+			addresses: []string{"fd00::fd", "fd00::fe", "fd00::ff"},
+		},
+		{
+			name:      "ipv6 exclusions",
+			sw:        pooledSwarm("foo", 3, "fd00::/120", "fd00::1-fd00::f", "fd00::10/126"),
+			addresses: []string{"fd00::14", "fd00::15", "fd00::16"},
+		},
+		{
+			name:      "ipv6 exhausted",
+			sw:        pooledSwarm("foo", 5, "fd00::/120", "fd00::/121", "fd00::80-fd00::fd"),
+			addresses: []string{"fd00::fe", "fd00::ff"},
+			missing:   3,
+		},
+		{
+			name:      "exhausted pool",
+			sw:        pooledSwarm("foo", 4, "10.9.9.0/29", "10.9.9.1-10.9.9.4"),
+			addresses: []string{"10.9.9.5", "10.9.9.6"},
+			missing:   2,
+		},
+		{
+			name: "address held by another swarm",
+			sw:   pooledSwarm("foo", 2, "10.9.9.0/24"),
+			holders: []*swarmv1alpha1.Swarm{func() *swarmv1alpha1.Swarm {
+				bar := pooledSwarm("bar", 1, "10.9.9.0/24")
+				bar.Status.Addresses = []swarmv1alpha1.PeerAddress{{Index: 0, Address: "10.9.9.1"}}
+				bar.Spec.Peers = []swarmv1alpha1.Peer{{Index: 1, ID: "bar-1", Address: "10.9.9.3"}}
+				return bar
+			}()},
+			addresses: []string{"10.9.9.2", "10.9.9.4"},
+		},
+		{
+			name: "pool exhausted by another swarm",
+			sw:   pooledSwarm("foo", 2, "10.9.9.0/30"),
+			holders: []*swarmv1alpha1.Swarm{func() *swarmv1alpha1.Swarm {
+				bar := pooledSwarm("bar", 2, "10.9.9.0/30")
+				bar.Status.Addresses = []swarmv1alpha1.PeerAddress{{Index: 0, Address: "10.9.9.1"}, {Index: 1, Address: "10.9.9.2"}}
+				return bar
+			}()},
+			missing: 2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, SwarmIndexers())
+			for _, holder := range tt.holders {
+				if err := indexer.Add(holder); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			addresses, missing, err := newIPAM(indexer).allocate(swarmKey(tt.sw), tt.sw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if missing != tt.missing {
+				t.Errorf("expected %d peers missing an address, got %d", tt.missing, missing)
+			}
+			var got []string
+			for _, a := range addresses {
+				got = append(got, a.Address)
+			}
+			if len(got) != len(tt.addresses) {
+				t.Fatalf("expected %v allocated, got %v", tt.addresses, got)
+			}
+			for i := range got {
+				if got[i] != tt.addresses[i] || addresses[i].Index != i {
+					t.Fatalf("expected %v allocated by index, got %v", tt.addresses, addresses)
+				}
+			}
+		})
+	}
+}
+
+func TestIPAMKeepsAllocationsAndReservations(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, SwarmIndexers())
+	a := newIPAM(indexer)
+	foo := pooledSwarm("foo", 2, "10.9.9.0/24")
+	bar := pooledSwarm("bar", 1, "10.9.9.0/24")
+
+	addresses, _, err := a.allocate(swarmKey(foo), foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not observed on the cache yet, the reservation holds them
+	barAddresses, _, err := a.allocate(swarmKey(bar), bar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(barAddresses) != 1 || barAddresses[0].Address != "10.9.9.3" {
+		t.Fatalf("expected bar allocated past the foo reservations, got %v", barAddresses)
+	}
+
+	// scaling up keeps the allocated addresses
+	foo.Status.Addresses = addresses
+	foo.Spec.Replicas = 3
+	addresses, _, err = a.allocate(swarmKey(foo), foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 3 || addresses[0].Address != "10.9.9.1" || addresses[1].Address != "10.9.9.2" || addresses[2].Address != "10.9.9.4" {
+		t.Fatalf("expected foo keeping its addresses, got %v", addresses)
+	}
+
+	// released addresses are free again
+	a.release(swarmKey(bar))
+	foo.Status.Addresses = addresses
+	foo.Spec.Replicas = 4
+	addresses, _, err = a.allocate(swarmKey(foo), foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addresses[3].Address != "10.9.9.3" {
+		t.Errorf("expected the released 10.9.9.3 allocated, got %v", addresses)
+	}
+}
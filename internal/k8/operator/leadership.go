@@ -0,0 +1,299 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// peerLeaderLabel is "true" on the leader pod only, the leader Service
+	// selects on it.
+	peerLeaderLabel = swarmLabel + "-leader"
+	// defaultStatusPeriod is how often peers are queried when the status
+	// endpoint sets no period
+	defaultStatusPeriod = time.Second * 10
+	// peerRequestTimeout bounds a single request to a peer endpoint
+	peerRequestTimeout = time.Second * 2
+	// peerStateLeader is the state reported by the leader peer
+	peerStateLeader = "Leader"
+)
+
+// peerState is the answer of a peer status endpoint
+type peerState struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Term   int64  `json:"term"`
+	Leader string `json:"leader,omitempty"`
+	// CommitIndex and AppliedIndex report the peer replication progress
+	CommitIndex  uint64 `json:"commitIndex"`
+	AppliedIndex uint64 `json:"appliedIndex"`
+}
+
+// leaderServiceName returns the Service selecting the swarm leader
+func leaderServiceName(sw *swarmv1alpha1.Swarm) string {
+	return sw.Name + "-leader"
+}
+
+// newPeerClient returns the client querying peer endpoints. Redirects are
+// not followed, peers answer for themselves and a follower redirecting to
+// the leader must not be taken for it.
+func newPeerClient() *http.Client {
+	return &http.Client{
+		Timeout: peerRequestTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// peerURL returns the URL of endpoint on the peer pod
+func peerURL(pod *corev1.Pod, endpoint swarmv1alpha1.HTTPEndpoint) string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(endpoint.Port))),
+		Path:   endpoint.Path,
+	}
+	return u.String()
+}
+
+// queryPeer reads the consensus state of the peer running on pod
+func (c *Controller) queryPeer(ctx context.Context, pod *corev1.Pod, endpoint swarmv1alpha1.HTTPEndpoint) (*peerState, error) {
+	ctx, cancel := context.WithTimeout(ctx, peerRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peerURL(pod, endpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.peerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s status endpoint answered %s", pod.Name, resp.Status)
+	}
+
+	state := &peerState{}
+	if err := json.NewDecoder(resp.Body).Decode(state); err != nil {
+		return nil, fmt.Errorf("peer %s status decode: %v", pod.Name, err)
+	}
+	return state, nil
+}
+
+// discoverLeader queries every scheduled peer at once and returns the pod
+// reporting itself as leader on the highest term, nil when none does, along
+// with the highest term seen.
+func (c *Controller) discoverLeader(ctx context.Context, key string, pods []*corev1.Pod, endpoint swarmv1alpha1.HTTPEndpoint) (*corev1.Pod, *peerState, int64) {
+	states := make([]*peerState, len(pods))
+	var wg sync.WaitGroup
+	for i, pod := range pods {
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, pod *corev1.Pod) {
+			defer wg.Done()
+			state, err := c.queryPeer(ctx, pod, endpoint)
+			if err != nil {
+				klog.V(4).Infof("instance %s: peer %s status query failed: %v", key, pod.Name, err)
+				return
+			}
+			states[i] = state
+		}(i, pod)
+	}
+	wg.Wait()
+
+	var leader *corev1.Pod
+	var leaderState *peerState
+	var term int64
+	for i, state := range states {
+		if state == nil {
+			continue
+		}
+		if state.Term > term {
+			term = state.Term
+		}
+		if state.State != peerStateLeader {
+			continue
+		}
+		if leaderState == nil || state.Term > leaderState.Term {
+			leader, leaderState = pods[i], state
+		}
+	}
+
+	return leader, leaderState, term
+}
+
+// reconcileLeader discovers the swarm leader through the peer status
+// endpoints, records it on status, labels the leader pod and keeps the leader
+// Service selecting it. It returns when peers must be queried again, leader
+// discovery is off while the swarm has no status endpoint.
+func (c *Controller) reconcileLeader(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) (time.Duration, error) {
+	endpoint := sw.Spec.Endpoints.Status
+	if endpoint == nil {
+		sw.Status.Leader = ""
+		sw.Status.LeaderID = ""
+		return time.Duration(0), c.deleteLeaderService(ctx, key, sw)
+	}
+
+	period := defaultStatusPeriod
+	if endpoint.PeriodSeconds > 0 {
+		period = time.Duration(endpoint.PeriodSeconds) * time.Second
+	}
+
+	leader, state, term := c.discoverLeader(ctx, key, pods, endpoint.HTTPEndpoint)
+	if term > 0 {
+		sw.Status.Term = term
+	}
+
+	var leaderName, leaderID string
+	if leader != nil {
+		leaderName, leaderID = leader.Name, state.ID
+	}
+	if leaderName != sw.Status.Leader && leaderName != "" {
+		klog.Infof("instance %s: peer %s leads on term %d", key, leaderName, state.Term)
+		c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonLeaderElected, "Peer %s on pod %s leads on term %d", leaderID, leaderName, state.Term)
+	}
+	sw.Status.Leader = leaderName
+	sw.Status.LeaderID = leaderID
+
+	if t := sw.Status.LeaderTransfer; t != nil && leaderName != "" && leaderName != t.From {
+		klog.Infof("instance %s: leadership moved from %s to %s", key, t.From, leaderName)
+		c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonLeaderTransfer, "Leadership moved from %s to %s", t.From, leaderName)
+		sw.Status.LeaderTransfer = nil
+	}
+
+	for _, pod := range pods {
+		if err := c.labelPod(ctx, pod, peerLeaderLabel, strconv.FormatBool(pod.Name == leaderName)); err != nil {
+			return period, err
+		}
+	}
+
+	return period, c.reconcileLeaderService(ctx, key, sw)
+}
+
+// newLeaderService returns the Service selecting the leader pod only, it
+// exposes the template container ports or the status port when there are
+// none.
+func newLeaderService(sw *swarmv1alpha1.Swarm) *corev1.Service {
+	var ports []corev1.ServicePort
+	if sw.Spec.Template != nil {
+		for _, container := range sw.Spec.Template.Spec.Containers {
+			for _, port := range container.Ports {
+				protocol := port.Protocol
+				if protocol == "" {
+					protocol = corev1.ProtocolTCP
+				}
+				ports = append(ports, corev1.ServicePort{
+					Name:       port.Name,
+					Port:       port.ContainerPort,
+					TargetPort: intstr.FromInt(int(port.ContainerPort)),
+					Protocol:   protocol,
+				})
+			}
+		}
+	}
+	if len(ports) == 0 && sw.Spec.Endpoints.Status != nil {
+		ports = append(ports, corev1.ServicePort{
+			Name:       "status",
+			Port:       sw.Spec.Endpoints.Status.Port,
+			TargetPort: intstr.FromInt(int(sw.Spec.Endpoints.Status.Port)),
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            leaderServiceName(sw),
+			Namespace:       sw.Namespace,
+			Labels:          map[string]string{swarmLabel: sw.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				swarmLabel:      sw.Name,
+				peerLeaderLabel: "true",
+			},
+			Ports: ports,
+		},
+	}
+}
+
+// reconcileLeaderService creates or updates the leader Service
+func (c *Controller) reconcileLeaderService(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) error {
+	return c.reconcileService(ctx, key, sw, newLeaderService(sw))
+}
+
+// reconcileService creates svc or updates the selector, ports and not ready
+// publishing of the swarm owned Service it names
+func (c *Controller) reconcileService(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, svc *corev1.Service) error {
+	client := c.kubeClientset.CoreV1().Services(sw.Namespace)
+
+	found, err := c.serviceLister.Services(sw.Namespace).Get(svc.Name)
+	if errors.IsNotFound(err) {
+		klog.Infof("instance %s: service created: name=%s", key, svc.Name)
+		_, err = client.Create(ctx, svc, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(found, sw) {
+		klog.Infof("instance %s: service %s exists but is not owned by the swarm", key, found.Name)
+		return nil
+	}
+
+	if reflect.DeepEqual(found.Spec.Selector, svc.Spec.Selector) && reflect.DeepEqual(found.Spec.Ports, svc.Spec.Ports) &&
+		found.Spec.PublishNotReadyAddresses == svc.Spec.PublishNotReadyAddresses {
+		return nil
+	}
+
+	updated := found.DeepCopy()
+	updated.Spec.Selector = svc.Spec.Selector
+	updated.Spec.Ports = svc.Spec.Ports
+	updated.Spec.PublishNotReadyAddresses = svc.Spec.PublishNotReadyAddresses
+	klog.Infof("instance %s: service updated: name=%s", key, svc.Name)
+	_, err = client.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteLeaderService removes the leader Service once discovery is disabled
+func (c *Controller) deleteLeaderService(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) error {
+	found, err := c.serviceLister.Services(sw.Namespace).Get(leaderServiceName(sw))
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(found, sw) {
+		return nil
+	}
+
+	err = c.kubeClientset.CoreV1().Services(sw.Namespace).Delete(ctx, found.Name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	klog.Infof("instance %s: leader service deleted: name=%s", key, found.Name)
+	return err
+}
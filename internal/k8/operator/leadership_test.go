@@ -0,0 +1,144 @@
+package operator
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// leaderFixture runs a three peer swarm with a status endpoint, every peer
+// answering as a follower on term 1 until told otherwise
+type leaderFixture struct {
+	*peerFixture
+}
+
+func newLeaderFixture(t *testing.T) *leaderFixture {
+	return &leaderFixture{newPeerFixture(t, nil, withStatusEndpoint(newTestSwarm("foo", 3)))}
+}
+
+// lead makes the peer at index answer as leader on term
+func (f *leaderFixture) lead(index int, term int64) {
+	f.peers[index].set(func(s *peer.Status) {
+		s.State = peerStateLeader
+		s.Term = term
+	})
+}
+
+func (f *leaderFixture) reconcile() {
+	f.t.Helper()
+	if _, err := f.controller.reconcileLeader(context.Background(), swarmKey(f.sw), f.sw, f.pods); err != nil {
+		f.t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// leaderLabels returns the leader label of every peer pod on the API
+func (f *leaderFixture) leaderLabels() []string {
+	f.t.Helper()
+	var values []string
+	for _, pod := range f.pods {
+		found, err := f.kubeClient.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		values = append(values, found.Labels[peerLeaderLabel])
+	}
+	return values
+}
+
+func expectLabels(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected leader labels %v, got %v", want, got)
+			return
+		}
+	}
+}
+
+func TestLeaderDiscovered(t *testing.T) {
+	f := newLeaderFixture(t)
+	f.lead(1, 3)
+
+	f.reconcile()
+
+	if f.sw.Status.Leader != "foo-1" || f.sw.Status.LeaderID != "foo-1" || f.sw.Status.Term != 3 {
+		t.Errorf("expected foo-1 leading on term 3, got %s (%s) on term %d", f.sw.Status.Leader, f.sw.Status.LeaderID, f.sw.Status.Term)
+	}
+	expectLabels(t, f.leaderLabels(), "false", "true", "false")
+	if events := f.events(); !hasEvent(events, ReasonLeaderElected) {
+		t.Errorf("expected a LeaderElected event, got %v", events)
+	}
+
+	svc, err := f.kubeClient.CoreV1().Services(f.sw.Namespace).Get(context.Background(), leaderServiceName(f.sw), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the leader service created: %v", err)
+	}
+	if svc.Spec.Selector[peerLeaderLabel] != "true" || svc.Spec.Selector[swarmLabel] != "foo" {
+		t.Errorf("expected the leader service to select the leader pod, got %v", svc.Spec.Selector)
+	}
+
+	// a known leader raises no event again
+	f.reconcile()
+	if events := f.events(); hasEvent(events, ReasonLeaderElected) {
+		t.Errorf("expected no event for the same leader, got %v", events)
+	}
+}
+
+func TestFollowerRedirectIsNotLeader(t *testing.T) {
+	f := newLeaderFixture(t)
+	f.lead(2, 2)
+	// foo-0 forwards its status request to the leader
+	f.fixture.peers.servePod(f.pods[0], http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, peerURL(f.pods[2], f.sw.Spec.Endpoints.Status.HTTPEndpoint), http.StatusTemporaryRedirect)
+	}))
+
+	f.reconcile()
+
+	if f.sw.Status.Leader != "foo-2" {
+		t.Errorf("expected foo-2 leading, got %q", f.sw.Status.Leader)
+	}
+	expectLabels(t, f.leaderLabels(), "false", "false", "true")
+}
+
+func TestUnreachableLeader(t *testing.T) {
+	f := newLeaderFixture(t)
+	f.lead(0, 2)
+	f.reconcile()
+	if f.sw.Status.Leader != "foo-0" {
+		t.Fatalf("expected foo-0 leading, got %q", f.sw.Status.Leader)
+	}
+
+	f.fixture.peers.drop("10.0.0.1:8080")
+	f.peers[1].set(func(s *peer.Status) { s.Term = 2 })
+	f.reconcile()
+
+	if f.sw.Status.Leader != "" || f.sw.Status.LeaderID != "" {
+		t.Errorf("expected no leader while it is unreachable, got %q (%q)", f.sw.Status.Leader, f.sw.Status.LeaderID)
+	}
+	if f.sw.Status.Term != 2 {
+		t.Errorf("expected term 2 kept from the followers, got %d", f.sw.Status.Term)
+	}
+	expectLabels(t, f.leaderLabels(), "false", "false", "false")
+}
+
+func TestLeaderTermChange(t *testing.T) {
+	f := newLeaderFixture(t)
+	f.lead(0, 2)
+	f.reconcile()
+	f.events()
+
+	// foo-0 did not step down yet, foo-2 was elected on a later term
+	f.lead(2, 3)
+	f.reconcile()
+
+	if f.sw.Status.Leader != "foo-2" || f.sw.Status.Term != 3 {
+		t.Errorf("expected foo-2 leading on term 3, got %s on term %d", f.sw.Status.Leader, f.sw.Status.Term)
+	}
+	expectLabels(t, f.leaderLabels(), "false", "false", "true")
+	if events := f.events(); !hasEvent(events, ReasonLeaderElected) {
+		t.Errorf("expected a LeaderElected event on the new term, got %v", events)
+	}
+}
@@ -0,0 +1,393 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	bolt "go.etcd.io/bbolt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// membershipKey is the ConfigMap key holding the swarm membership
+	membershipKey = "membership.json"
+	// membershipBucket is the bolt bucket holding memberships by swarm key
+	membershipBucket = "membership"
+
+	membershipAdd     = "add"
+	membershipRemove  = "remove"
+	membershipPromote = "promote"
+)
+
+// Membership is the Pool membership applied for a swarm, along with the
+// change in progress
+type Membership struct {
+	// UID is the swarm the membership belongs to, memberships of a deleted
+	// swarm are not reused by a new one with the same name
+	UID types.UID `json:"uid"`
+	// Members are the peers added to the Pool by peer ID
+	Members map[string]MemberRecord `json:"members,omitempty"`
+	// Pending is the change sent to the Pool and not known to be applied
+	Pending *MembershipChange `json:"pending,omitempty"`
+	// LastState is the swarm as last handled by the generic handler
+	LastState *swarmv1alpha1.Swarm `json:"lastState,omitempty"`
+}
+
+// MemberRecord is a peer added to the Pool
+type MemberRecord struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+	Voter   bool   `json:"voter"`
+}
+
+// MembershipChange is a Pool change in progress
+type MembershipChange struct {
+	Op        string    `json:"op"`
+	ID        string    `json:"id"`
+	Index     int       `json:"index"`
+	Address   string    `json:"address,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// MembershipStore persists the Pool membership of swarms across restarts
+type MembershipStore interface {
+	Load(ctx context.Context, sw *swarmv1alpha1.Swarm) (*Membership, error)
+	Save(ctx context.Context, sw *swarmv1alpha1.Swarm, m *Membership) error
+}
+
+func newMembership(sw *swarmv1alpha1.Swarm) *Membership {
+	return &Membership{UID: sw.UID, Members: map[string]MemberRecord{}}
+}
+
+// decodeMembership returns the stored membership of sw, an empty one when
+// none is stored or it belongs to a former swarm
+func decodeMembership(sw *swarmv1alpha1.Swarm, data []byte) (*Membership, error) {
+	if len(data) == 0 {
+		return newMembership(sw), nil
+	}
+
+	m := &Membership{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.UID != sw.UID {
+		return newMembership(sw), nil
+	}
+	if m.Members == nil {
+		m.Members = map[string]MemberRecord{}
+	}
+	return m, nil
+}
+
+// MembershipVerifier is a Pool reading membership back from the swarm
+// leader
+type MembershipVerifier interface {
+	IsMember(ctx context.Context, sw *swarmv1alpha1.Swarm, id string) (bool, error)
+}
+
+// Resumer is a Pool finishing the changes a previous run left pending
+type Resumer interface {
+	Resume(ctx context.Context, swarms []*swarmv1alpha1.Swarm) error
+}
+
+// durablePool records every change applied on a Pool, so peers added before
+// a restart are not added again. Changes are recorded as pending before
+// reaching the Pool. A pending change is settled before any other change of
+// the swarm and by Resume on startup: it is applied again, or rolled back
+// when the swarm no longer wants the peer. Peers recorded as added are
+// checked against the leader when the Pool can verify membership, a peer
+// removed out of band is added again. The workqueue never syncs a swarm
+// concurrently, changes of a swarm are never interleaved.
+type durablePool struct {
+	pool  Pool
+	store MembershipStore
+}
+
+// NewDurablePool returns pool recording its membership on store
+func NewDurablePool(pool Pool, store MembershipStore) Pool {
+	return &durablePool{pool: pool, store: store}
+}
+
+func (p *durablePool) Add(ctx context.Context, sw *swarmv1alpha1.Swarm, idx int, id string, ip net.IP) error {
+	m, err := p.load(ctx, sw)
+	if err != nil {
+		return err
+	}
+
+	// peers join on their stable DNS name and keep their suffrage when
+	// joining again
+	record := MemberRecord{Index: idx, Address: peerHost(sw, idx)}
+	if current, ok := m.Members[id]; ok {
+		record.Voter = current.Voter
+		if current == record {
+			member, err := p.isMember(ctx, sw, id)
+			if err != nil {
+				return err
+			}
+			if member {
+				klog.V(4).Infof("swarm %s: peer %s already added on %s", sw.Name, id, record.Address)
+				return nil
+			}
+			klog.Warningf("swarm %s: peer %s recorded as added is not a member, adding it again", sw.Name, id)
+			record.Voter = false
+		}
+	}
+
+	if err := p.begin(ctx, sw, m, &MembershipChange{Op: membershipAdd, ID: id, Index: idx, Address: record.Address}); err != nil {
+		return err
+	}
+	if err := p.pool.Add(ctx, sw, idx, id, ip); err != nil {
+		return err
+	}
+
+	m.Members[id] = record
+	m.Pending = nil
+	return p.store.Save(ctx, sw, m)
+}
+
+func (p *durablePool) Remove(ctx context.Context, sw *swarmv1alpha1.Swarm, idx int, id string) error {
+	m, err := p.load(ctx, sw)
+	if err != nil {
+		return err
+	}
+
+	// peers may have been added before membership was recorded, removals
+	// always reach the Pool
+	if err := p.begin(ctx, sw, m, &MembershipChange{Op: membershipRemove, ID: id, Index: idx}); err != nil {
+		return err
+	}
+	if err := p.pool.Remove(ctx, sw, idx, id); err != nil {
+		return err
+	}
+
+	delete(m.Members, id)
+	m.Pending = nil
+	return p.store.Save(ctx, sw, m)
+}
+
+func (p *durablePool) Promote(ctx context.Context, sw *swarmv1alpha1.Swarm, idx int, id string) error {
+	m, err := p.load(ctx, sw)
+	if err != nil {
+		return err
+	}
+
+	if err := p.begin(ctx, sw, m, &MembershipChange{Op: membershipPromote, ID: id, Index: idx}); err != nil {
+		return err
+	}
+	if err := p.pool.Promote(ctx, sw, idx, id); err != nil {
+		return err
+	}
+
+	promoted(m, id)
+	m.Pending = nil
+	return p.store.Save(ctx, sw, m)
+}
+
+// Resume settles the changes left pending on the stored membership of
+// swarms, it goes on with the next swarm when one fails
+func (p *durablePool) Resume(ctx context.Context, swarms []*swarmv1alpha1.Swarm) error {
+	var errs []error
+	for _, sw := range swarms {
+		if _, err := p.load(ctx, sw); err != nil {
+			errs = append(errs, fmt.Errorf("swarm %s: %v", sw.Name, err))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// load returns the stored membership of sw with no change pending
+func (p *durablePool) load(ctx context.Context, sw *swarmv1alpha1.Swarm) (*Membership, error) {
+	m, err := p.store.Load(ctx, sw)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.settle(ctx, sw, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// settle applies the change pending on m again. Additions of peers the
+// swarm no longer holds are rolled back, and promotions of peers no longer
+// voting dropped.
+func (p *durablePool) settle(ctx context.Context, sw *swarmv1alpha1.Swarm, m *Membership) error {
+	change := m.Pending
+	if change == nil {
+		return nil
+	}
+	klog.Infof("swarm %s: settling %s of peer %s started at %s", sw.Name, change.Op, change.ID, change.StartedAt.Format(time.RFC3339))
+
+	wanted := change.Index < sw.Spec.Replicas && peerID(sw, change.Index) == change.ID
+	var err error
+	switch {
+	case change.Op == membershipAdd && wanted:
+		if err = p.pool.Add(ctx, sw, change.Index, change.ID, nil); err == nil {
+			m.Members[change.ID] = MemberRecord{Index: change.Index, Address: change.Address}
+		}
+	case change.Op == membershipAdd || change.Op == membershipRemove:
+		if err = p.pool.Remove(ctx, sw, change.Index, change.ID); err == nil {
+			delete(m.Members, change.ID)
+		}
+	case change.Op == membershipPromote && wanted && votingRole(desiredRole(sw, change.Index)):
+		if err = p.pool.Promote(ctx, sw, change.Index, change.ID); err == nil {
+			promoted(m, change.ID)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("settling %s of peer %s: %v", change.Op, change.ID, err)
+	}
+
+	m.Pending = nil
+	return p.store.Save(ctx, sw, m)
+}
+
+// begin records change as pending, m has no change pending once loaded
+func (p *durablePool) begin(ctx context.Context, sw *swarmv1alpha1.Swarm, m *Membership, change *MembershipChange) error {
+	change.StartedAt = time.Now()
+	m.Pending = change
+	return p.store.Save(ctx, sw, m)
+}
+
+// isMember checks id on the leader when the Pool can, trusting the stored
+// membership otherwise
+func (p *durablePool) isMember(ctx context.Context, sw *swarmv1alpha1.Swarm, id string) (bool, error) {
+	verifier, ok := p.pool.(MembershipVerifier)
+	if !ok {
+		return true, nil
+	}
+	return verifier.IsMember(ctx, sw, id)
+}
+
+func promoted(m *Membership, id string) {
+	if record, ok := m.Members[id]; ok {
+		record.Voter = true
+		m.Members[id] = record
+	}
+}
+
+// configMapMembershipStore keeps each swarm membership on a ConfigMap owned
+// by the swarm, removed along with it
+type configMapMembershipStore struct {
+	client kubernetes.Interface
+}
+
+// NewConfigMapMembershipStore returns a MembershipStore on ConfigMaps
+func NewConfigMapMembershipStore(client kubernetes.Interface) MembershipStore {
+	return &configMapMembershipStore{client: client}
+}
+
+// membershipConfigMapName returns the ConfigMap holding the swarm membership
+func membershipConfigMapName(sw *swarmv1alpha1.Swarm) string {
+	return sw.Name + "-membership"
+}
+
+func (s *configMapMembershipStore) Load(ctx context.Context, sw *swarmv1alpha1.Swarm) (*Membership, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(sw.Namespace).Get(ctx, membershipConfigMapName(sw), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return newMembership(sw), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembership(sw, []byte(cm.Data[membershipKey]))
+}
+
+func (s *configMapMembershipStore) Save(ctx context.Context, sw *swarmv1alpha1.Swarm, m *Membership) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	client := s.client.CoreV1().ConfigMaps(sw.Namespace)
+	cm, err := client.Get(ctx, membershipConfigMapName(sw), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            membershipConfigMapName(sw),
+				Namespace:       sw.Namespace,
+				Labels:          map[string]string{swarmLabel: sw.Name},
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+			},
+			Data: map[string]string{membershipKey: string(data)},
+		}
+		_, err = client.Create(ctx, cm, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	updated := cm.DeepCopy()
+	if updated.Data == nil {
+		updated.Data = map[string]string{}
+	}
+	updated.Data[membershipKey] = string(data)
+	_, err = client.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// BoltMembershipStore keeps swarm memberships on a local bolt file, meant
+// for development outside the cluster
+type BoltMembershipStore struct {
+	db *bolt.DB
+}
+
+// NewBoltMembershipStore opens or creates the bolt file at path
+func NewBoltMembershipStore(path string) (*BoltMembershipStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(membershipBucket))
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &BoltMembershipStore{db: db}, nil
+}
+
+func (s *BoltMembershipStore) Load(_ context.Context, sw *swarmv1alpha1.Swarm) (*Membership, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// values are only valid within the transaction
+		data = append(data, tx.Bucket([]byte(membershipBucket)).Get(membershipStoreKey(sw))...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembership(sw, data)
+}
+
+func (s *BoltMembershipStore) Save(_ context.Context, sw *swarmv1alpha1.Swarm, m *Membership) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(membershipBucket)).Put(membershipStoreKey(sw), data)
+	})
+}
+
+// Close releases the bolt file
+func (s *BoltMembershipStore) Close() error {
+	return s.db.Close()
+}
+
+func membershipStoreKey(sw *swarmv1alpha1.Swarm) []byte {
+	return []byte(sw.Namespace + "/" + sw.Name)
+}
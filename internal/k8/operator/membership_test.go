@@ -0,0 +1,179 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	"k8s.io/client-go/tools/record"
+)
+
+// openBolt opens the membership bolt file at path, closed with the test
+func openBolt(t *testing.T, path string) *BoltMembershipStore {
+	t.Helper()
+	store, err := NewBoltMembershipStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func expectCalls(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected pool calls %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected pool calls %v, got %v", want, got)
+		}
+	}
+}
+
+func TestDurablePoolRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "membership.db")
+	sw := newTestSwarm("foo", 3)
+	pool := &recordingPool{}
+
+	store := openBolt(t, path)
+	durable := NewDurablePool(pool, store)
+	if err := durable.Add(ctx, sw, 0, "foo-0", nil); err != nil {
+		t.Fatalf("unexpected add error: %v", err)
+	}
+	if err := durable.Add(ctx, sw, 1, "foo-1", nil); err != nil {
+		t.Fatalf("unexpected add error: %v", err)
+	}
+	// the second change fails midway and stays pending
+	pool.err = errors.New("leader unreachable")
+	if err := durable.Add(ctx, sw, 2, "foo-2", nil); err == nil {
+		t.Fatal("expected the add to fail")
+	}
+	pool.drain()
+	pool.err = nil
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart against the same file
+	store = openBolt(t, path)
+	durable = NewDurablePool(pool, store)
+	if err := durable.(Resumer).Resume(ctx, []*swarmv1alpha1.Swarm{sw}); err != nil {
+		t.Fatalf("unexpected resume error: %v", err)
+	}
+	expectCalls(t, pool.drain(), "add foo-2")
+
+	m, err := store.Load(ctx, sw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Pending != nil || len(m.Members) != 3 {
+		t.Fatalf("expected three members and nothing pending, got %+v", m)
+	}
+	if m.Members["foo-2"].Address != peerHost(sw, 2) {
+		t.Errorf("expected foo-2 recorded on %s, got %+v", peerHost(sw, 2), m.Members["foo-2"])
+	}
+
+	// members the leader holds are not added again
+	for i, id := range []string{"foo-0", "foo-1", "foo-2"} {
+		if err := durable.Add(ctx, sw, i, id, nil); err != nil {
+			t.Fatalf("unexpected add error: %v", err)
+		}
+	}
+	expectCalls(t, pool.drain())
+
+	// a member removed out of band is added again
+	delete(pool.members, "foo-1")
+	if err := durable.Add(ctx, sw, 1, "foo-1", nil); err != nil {
+		t.Fatalf("unexpected add error: %v", err)
+	}
+	expectCalls(t, pool.drain(), "add foo-1")
+}
+
+func TestDurablePoolRollsBackUnwantedAdd(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "membership.db")
+	sw := newTestSwarm("foo", 3)
+	pool := &recordingPool{}
+
+	durable := NewDurablePool(pool, openBolt(t, path))
+	pool.err = errors.New("leader unreachable")
+	if err := durable.Add(ctx, sw, 2, "foo-2", nil); err == nil {
+		t.Fatal("expected the add to fail")
+	}
+	pool.drain()
+	pool.err = nil
+
+	// the swarm was scaled down meanwhile
+	sw.Spec.Replicas = 2
+	if err := durable.(Resumer).Resume(ctx, []*swarmv1alpha1.Swarm{sw}); err != nil {
+		t.Fatalf("unexpected resume error: %v", err)
+	}
+	expectCalls(t, pool.drain(), "remove foo-2")
+}
+
+func TestDurablePoolSettlesPendingBeforeOtherChanges(t *testing.T) {
+	ctx := context.Background()
+	sw := newTestSwarm("foo", 3)
+	pool := &recordingPool{}
+	store := openBolt(t, filepath.Join(t.TempDir(), "membership.db"))
+
+	durable := NewDurablePool(pool, store)
+	pool.err = errors.New("leader unreachable")
+	if err := durable.Add(ctx, sw, 2, "foo-2", nil); err == nil {
+		t.Fatal("expected the add to fail")
+	}
+	pool.drain()
+
+	// the pending add is settled first, the removal waits for it
+	if err := durable.Remove(ctx, sw, 0, "foo-0"); err == nil {
+		t.Fatal("expected the removal held while the pending add fails")
+	}
+	expectCalls(t, pool.drain(), "add foo-2")
+
+	pool.err = nil
+	if err := durable.Remove(ctx, sw, 0, "foo-0"); err != nil {
+		t.Fatalf("unexpected remove error: %v", err)
+	}
+	expectCalls(t, pool.drain(), "add foo-2", "remove foo-0")
+	m, err := store.Load(ctx, sw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Members["foo-2"]; !ok || m.Pending != nil {
+		t.Errorf("expected foo-2 recorded and nothing pending, got %+v", m)
+	}
+}
+
+func TestDurableHandlerRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "membership.db")
+	sw := newTestSwarm("foo", 2)
+	sw.Spec.Peers = []swarmv1alpha1.Peer{
+		{ID: "a", Index: 0, Address: "10.1.0.1"},
+		{ID: "b", Index: 1, Address: "10.1.0.2"},
+	}
+	pool := &recordingPool{}
+
+	store := openBolt(t, path)
+	h := NewDurableHandler(pool, record.NewFakeRecorder(10), store)
+	h.Created(ctx, sw)
+	expectCalls(t, pool.drain(), "add a", "add b")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the swarm shows up as created again after a restart
+	store = openBolt(t, path)
+	h = NewDurableHandler(pool, record.NewFakeRecorder(10), store)
+	h.Created(ctx, sw)
+	expectCalls(t, pool.drain())
+
+	last, err := h.(*handler).Get(ctx, "foo")
+	if err != nil || last.Spec.Replicas != 2 {
+		t.Errorf("expected the last state restored, got %v err %v", last, err)
+	}
+}
@@ -0,0 +1,12 @@
+package operator
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var skippedUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "swarm",
+	Name:      "skipped_updates_total",
+	Help:      "Informer updates dropped by predicates as no-op changes.",
+}, []string{"controller", "resource"})
@@ -3,8 +3,12 @@ package operator
 import (
 	"context"
 	"errors"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
 	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -22,20 +26,43 @@ type Handler interface {
 	Deleted(ctx context.Context, obj runtime.Object)
 }
 
+// PeerFinder looks up the swarm holding a peer, the oldest one when peers
+// collide. The Controller and the handlers Build runs implement it.
+type PeerFinder interface {
+	FindByPeerID(namespace, id string) (*swarmv1alpha1.Swarm, error)
+	FindByPeerAddress(address string) (*swarmv1alpha1.Swarm, error)
+}
+
+// indexedHandler is a Handler looking objects up on the informer indexer
+type indexedHandler interface {
+	setIndexer(indexer cache.Indexer)
+}
+
 type ListWatcher interface {
 	List(options metav1.ListOptions) (runtime.Object, error)
 	Watch(options metav1.ListOptions) (watch.Interface, error)
 }
 
+// defaultHandlerTimeout bounds a single handler call when no timeout is configured
+const defaultHandlerTimeout = time.Second * 30
+
 type controller struct {
 	client   kubernetes.Interface
 	informer cache.SharedIndexInformer
 	queue    workqueue.RateLimitingInterface
 	handler  Handler
+	timeout  time.Duration
+	drainer  *drainer
 	ready    chan struct{}
 }
 
-func Build(handler Handler, listenObj runtime.Object, watcher ListWatcher) *controller {
+// Build creates a generic controller dispatching informer events to handler,
+// each handler call gets a context bounded by timeout. Updates rejected by
+// any of the predicates are skipped. Swarm informers are indexed by peer ID,
+// peer address and selector labels, handlers get the indexer to look them up.
+func Build(handler Handler, listenObj runtime.Object, watcher ListWatcher, timeout time.Duration, predicates ...UpdatePredicate) *controller {
+	resource := strings.ToLower(reflect.TypeOf(listenObj).Elem().Name())
+
 	informer := cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc:  watcher.List,
@@ -43,8 +70,11 @@ func Build(handler Handler, listenObj runtime.Object, watcher ListWatcher) *cont
 		},
 		listenObj,
 		0, // No Resync for now
-		cache.Indexers{},
+		indexersFor(listenObj),
 	)
+	if h, ok := handler.(indexedHandler); ok {
+		h.setIndexer(informer.GetIndexer())
+	}
 
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -62,6 +92,13 @@ func Build(handler Handler, listenObj runtime.Object, watcher ListWatcher) *cont
 			})
 		},
 		UpdateFunc: func(oldObj, newObj interface{}) {
+			for _, p := range predicates {
+				if !p(oldObj, newObj) {
+					skippedUpdates.WithLabelValues("handler", resource).Inc()
+					return
+				}
+			}
+
 			key, err := cache.MetaNamespaceKeyFunc(newObj)
 			log.Infof("Update obj: %s", key)
 			if err != nil {
@@ -91,31 +128,61 @@ func Build(handler Handler, listenObj runtime.Object, watcher ListWatcher) *cont
 		},
 	})
 
+	if timeout <= 0 {
+		timeout = defaultHandlerTimeout
+	}
+
 	return &controller{
 		informer: informer,
 		queue:    queue,
 		handler:  handler,
+		timeout:  timeout,
+		drainer:  newDrainer(),
 		ready:    make(chan struct{}),
 	}
 }
 
-func (c *controller) Run(stopCh <-chan struct{}) {
+// Run starts the informer and processes queued events until ctx is cancelled,
+// then it stops accepting events and waits up to gracePeriod for the handler
+// calls in progress. Calls still running then are cancelled and waited for.
+func (c *controller) Run(ctx context.Context, gracePeriod time.Duration) {
 	log.Info("controller.Run: initiating")
 
 	defer utilruntime.HandleCrash()
 	defer c.queue.ShutDown()
-	go c.informer.Run(stopCh)
+	go c.informer.Run(ctx.Done())
 
 	// wait until sync resources
-	if !cache.WaitForCacheSync(stopCh, c.HasSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), c.HasSynced) {
 		utilruntime.HandleError(errors.New("error syncing cache"))
 		return
 	}
 
 	close(c.ready)
 
-	// run the runWorker method every second with a stop channel
-	wait.Until(c.runWorker, time.Second, stopCh)
+	// handler calls run on their own context so they are not aborted until
+	// the grace period expires
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	// run the runWorker method every second until shutdown
+	var worker sync.WaitGroup
+	worker.Add(1)
+	go func() {
+		defer worker.Done()
+		wait.UntilWithContext(workCtx, c.runWorker, time.Second)
+	}()
+
+	<-ctx.Done()
+	log.Infof("controller.Run: shutting down, draining for up to %s", gracePeriod)
+
+	c.queue.ShutDown()
+	if abandoned := c.drainer.drain(gracePeriod); len(abandoned) > 0 {
+		log.Warnf("controller.Run: grace period expired, aborting in-flight events: %v", abandoned)
+	}
+	cancelWork()
+	worker.Wait()
+	log.Info("controller.Run: drained")
 }
 
 func (c *controller) WaitUntilReady() {
@@ -129,14 +196,14 @@ func (c *controller) HasSynced() bool {
 }
 
 // runWorker executes the loop to process new items added to the queue
-func (c *controller) runWorker() {
+func (c *controller) runWorker(ctx context.Context) {
 	log.Info("controller.runWorker: starting")
 
-	for c.processNextItem() {
+	for c.processNextItem(ctx) {
 	}
 }
 
-func (c *controller) processNextItem() bool {
+func (c *controller) processNextItem(ctx context.Context) bool {
 	ev, quit := c.queue.Get()
 	if quit {
 		return false
@@ -144,6 +211,12 @@ func (c *controller) processNextItem() bool {
 	defer c.queue.Done(ev)
 
 	e := ev.(Event)
+	if !c.drainer.begin(e.GetKey()) {
+		log.Infof("controller.processNextItem: abandoning key %s on shutdown", e.GetKey())
+		return true
+	}
+	defer c.drainer.end(e.GetKey())
+
 	item, exists, err := c.informer.GetIndexer().GetByKey(e.GetKey())
 	if err != nil {
 		log.Errorf("controller.processNextItem: Failed processing item with key %s with error %vs", e.GetKey(), err)
@@ -158,7 +231,9 @@ func (c *controller) processNextItem() bool {
 		return true
 	}
 
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
 	switch e.GetAction() {
 	case CREATED:
 		c.handler.Created(ctx, obj.DeepCopyObject())
@@ -0,0 +1,74 @@
+package operator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	"github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// waitFor polls condition until it holds or a second went by
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestBuildRunsHandlerOnSwarmChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	pool := &recordingPool{}
+	recorder := record.NewFakeRecorder(100)
+	c := Build(NewHandler(pool, recorder), &swarmv1alpha1.Swarm{}, NewAdapter(ctx, client.K8slabV1alpha1()), time.Second, SwarmUpdatePredicate())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx, time.Second)
+	}()
+	c.WaitUntilReady()
+
+	sw := newTestSwarm("foo", 1)
+	sw.Spec.Peers = []swarmv1alpha1.Peer{{ID: "a", Index: 0, Address: "10.0.0.1"}}
+	sw, err := client.K8slabV1alpha1().Swarms(sw.Namespace).Create(ctx, sw, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "spec peers added to the pool", func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.added) == 1 && pool.added[0] == "a"
+	})
+
+	// status writes keep the generation, the predicates skip them
+	skipped := testutil.ToFloat64(skippedUpdates.WithLabelValues("handler", "swarm"))
+	sw = sw.DeepCopy()
+	sw.ResourceVersion = "2"
+	sw.Status.Replicas = 1
+	if _, err := client.K8slabV1alpha1().Swarms(sw.Namespace).UpdateStatus(ctx, sw, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "status update skipped", func() bool {
+		return testutil.ToFloat64(skippedUpdates.WithLabelValues("handler", "swarm")) == skipped+1
+	})
+
+	cancel()
+	<-done
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if !hasEvent(events, ReasonMembershipChanged) {
+		t.Errorf("expected a %s event, got %v", ReasonMembershipChanged, events)
+	}
+}
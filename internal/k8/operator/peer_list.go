@@ -0,0 +1,193 @@
+package operator
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strings"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// peerListHashAnnotation holds the hash of the peer list a pod was last
+	// told about, workloads watch it through the downward API to reload
+	peerListHashAnnotation = swarmLabel + "-peer-list-hash"
+	// peerListDir is where the peer list is mounted on every container
+	peerListDir = "/etc/swarm/peers"
+	// peerListVolume is the pod volume projecting the peer list
+	peerListVolume = "swarm-peer-list"
+
+	peerListJSON = "peers.json"
+	peerListYAML = "peers.yaml"
+	peerListEnv  = "peers.env"
+)
+
+// peerListEntry is a swarm peer as published to workloads
+type peerListEntry struct {
+	Index   int    `json:"index"`
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+}
+
+// peerListName returns the ConfigMap publishing the swarm peer list
+func peerListName(sw *swarmv1alpha1.Swarm) string {
+	return sw.Name + "-peer-list"
+}
+
+// peerList returns the swarm peers by index: the managed peers below
+// replicas, on the address declared on spec peers, allocated from the
+// address pool or their stable DNS name otherwise, along with spec peers
+// declared above replicas.
+func peerList(sw *swarmv1alpha1.Swarm) []peerListEntry {
+	entries := map[int]peerListEntry{}
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		address := peerAddress(sw, i)
+		if address == "" {
+			address = peerHost(sw, i)
+		}
+		entries[i] = peerListEntry{Index: i, ID: peerID(sw, i), Address: address, Role: desiredRole(sw, i)}
+	}
+	for _, peer := range sw.Spec.Peers {
+		entry, ok := entries[peer.Index]
+		if !ok {
+			entry = peerListEntry{Index: peer.Index, ID: peer.ID, Role: desiredRole(sw, peer.Index)}
+		}
+		if peer.Address != "" {
+			entry.Address = peer.Address
+		}
+		entries[peer.Index] = entry
+	}
+
+	list := make([]peerListEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Index < list[j].Index
+	})
+	return list
+}
+
+// peerListData renders the peer list as JSON, YAML and env file
+func peerListData(sw *swarmv1alpha1.Swarm) map[string]string {
+	list := peerList(sw)
+
+	// plain structs always marshal
+	jsonData, _ := json.MarshalIndent(list, "", "  ")
+	yamlData, _ := yaml.Marshal(list)
+
+	var env strings.Builder
+	fmt.Fprintf(&env, "SWARM_PEER_COUNT=%d\n", len(list))
+	for _, entry := range list {
+		fmt.Fprintf(&env, "SWARM_PEER_%d_ID=%s\n", entry.Index, entry.ID)
+		fmt.Fprintf(&env, "SWARM_PEER_%d_ADDRESS=%s\n", entry.Index, entry.Address)
+		fmt.Fprintf(&env, "SWARM_PEER_%d_ROLE=%s\n", entry.Index, entry.Role)
+	}
+
+	return map[string]string{
+		peerListJSON: string(jsonData) + "\n",
+		peerListYAML: string(yamlData),
+		peerListEnv:  env.String(),
+	}
+}
+
+// peerListHash returns a short stable hash of the rendered peer list
+func peerListHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New32a()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(data[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// addPeerList mounts the peer list on every container of pod and annotates
+// it with the list hash
+func addPeerList(sw *swarmv1alpha1.Swarm, pod *corev1.Pod) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: peerListVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: peerListName(sw)},
+			},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+			Name:      peerListVolume,
+			MountPath: peerListDir,
+			ReadOnly:  true,
+		})
+	}
+	pod.Annotations[peerListHashAnnotation] = peerListHash(peerListData(sw))
+}
+
+// newPeerList returns the ConfigMap publishing data, owned by the swarm
+func newPeerList(sw *swarmv1alpha1.Swarm, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            peerListName(sw),
+			Namespace:       sw.Namespace,
+			Labels:          map[string]string{swarmLabel: sw.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+		},
+		Data: data,
+	}
+}
+
+// reconcilePeerList publishes the swarm peer list on its ConfigMap, every
+// format replaced at once, and annotates the peer pods with its hash.
+func (c *Controller) reconcilePeerList(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) error {
+	client := c.kubeClientset.CoreV1().ConfigMaps(sw.Namespace)
+	data := peerListData(sw)
+	hash := peerListHash(data)
+
+	found, err := c.configMapLister.ConfigMaps(sw.Namespace).Get(peerListName(sw))
+	switch {
+	case errors.IsNotFound(err):
+		klog.Infof("instance %s: peer list created: name=%s", key, peerListName(sw))
+		_, err = client.Create(ctx, newPeerList(sw, data), metav1.CreateOptions{})
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	case err != nil:
+		return err
+	case !metav1.IsControlledBy(found, sw):
+		klog.Infof("instance %s: config map %s exists but is not owned by the swarm", key, found.Name)
+		return nil
+	case !reflect.DeepEqual(found.Data, data):
+		updated := found.DeepCopy()
+		updated.Data = data
+		if _, err := client.Update(ctx, updated, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		klog.Infof("instance %s: peer list updated: name=%s hash=%s", key, found.Name, hash)
+		c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonPeerListUpdated, "Peer list %s updated to hash %s", found.Name, hash)
+	}
+
+	for _, pod := range pods {
+		if err := c.annotatePod(ctx, pod, peerListHashAnnotation, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// withPeerListPeers declares peer 1 on its own address and an external peer
+// above replicas
+func withPeerListPeers(sw *swarmv1alpha1.Swarm) *swarmv1alpha1.Swarm {
+	sw.Spec.Peers = []swarmv1alpha1.Peer{
+		{Index: 3, ID: "ext-3", Address: "10.1.0.4"},
+		{Index: 1, ID: "foo-1", Address: "10.1.0.2"},
+	}
+	return sw
+}
+
+func TestPeerListData(t *testing.T) {
+	data := peerListData(withPeerListPeers(newTestSwarm("foo", 2)))
+
+	for _, tt := range []struct {
+		format   string
+		expected string
+	}{
+		{
+			format: peerListJSON,
+			expected: `[
+  {
+    "index": 0,
+    "id": "foo-0",
+    "address": "foo-0.foo-peers.default.svc",
+    "role": "Voter"
+  },
+  {
+    "index": 1,
+    "id": "foo-1",
+    "address": "10.1.0.2",
+    "role": "Voter"
+  },
+  {
+    "index": 3,
+    "id": "ext-3",
+    "address": "10.1.0.4",
+    "role": "Voter"
+  }
+]
+`,
+		},
+		{
+			format: peerListYAML,
+			expected: `- address: foo-0.foo-peers.default.svc
+  id: foo-0
+  index: 0
+  role: Voter
+- address: 10.1.0.2
+  id: foo-1
+  index: 1
+  role: Voter
+- address: 10.1.0.4
+  id: ext-3
+  index: 3
+  role: Voter
+`,
+		},
+		{
+			format: peerListEnv,
+			expected: `SWARM_PEER_COUNT=3
+SWARM_PEER_0_ID=foo-0
+SWARM_PEER_0_ADDRESS=foo-0.foo-peers.default.svc
+SWARM_PEER_0_ROLE=Voter
+SWARM_PEER_1_ID=foo-1
+SWARM_PEER_1_ADDRESS=10.1.0.2
+SWARM_PEER_1_ROLE=Voter
+SWARM_PEER_3_ID=ext-3
+SWARM_PEER_3_ADDRESS=10.1.0.4
+SWARM_PEER_3_ROLE=Voter
+`,
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := data[tt.format]; got != tt.expected {
+				t.Errorf("expected %s:\n%s\ngot:\n%s", tt.format, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPeerListHashFollowsMembership(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	hash := peerListHash(peerListData(sw))
+	if again := peerListHash(peerListData(newTestSwarm("foo", 2))); again != hash {
+		t.Fatalf("expected a stable hash, got %s and %s", hash, again)
+	}
+
+	for name, change := range map[string]func(*swarmv1alpha1.Swarm){
+		"scaled": func(sw *swarmv1alpha1.Swarm) { sw.Spec.Replicas = 3 },
+		"replaced": func(sw *swarmv1alpha1.Swarm) {
+			sw.Status.Replacements = []swarmv1alpha1.PeerReplacement{{Index: 1, NewID: "foo-1-x9k2p"}}
+		},
+		"moved": func(sw *swarmv1alpha1.Swarm) {
+			sw.Spec.Peers = []swarmv1alpha1.Peer{{Index: 0, ID: "foo-0", Address: "10.1.0.1"}}
+		},
+	} {
+		changed := newTestSwarm("foo", 2)
+		change(changed)
+		if got := peerListHash(peerListData(changed)); got == hash {
+			t.Errorf("expected the hash to change when %s", name)
+		}
+	}
+}
+
+func TestReconcilePeerListAnnotatesPeers(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	pods := []*corev1.Pod{newTestPeer(sw, 0), newTestPeer(sw, 1)}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	if err := f.controller.reconcilePeerList(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+	configMaps := f.kubeClient.CoreV1().ConfigMaps(sw.Namespace)
+	cm, err := configMaps.Get(context.Background(), peerListName(sw), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(cm, sw) || cm.Data[peerListEnv] != peerListData(sw)[peerListEnv] {
+		t.Fatalf("expected the peer list published on a config map owned by foo, got %v", cm)
+	}
+	if err := f.kubeInformers.Core().V1().ConfigMaps().Informer().GetIndexer().Add(cm); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new member changes the list and every peer is told about it
+	sw.Spec.Replicas = 3
+	if err := f.controller.reconcilePeerList(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+	hash := peerListHash(peerListData(sw))
+	if hash == pods[0].Annotations[peerListHashAnnotation] {
+		t.Fatal("expected the scaled swarm to hash differently")
+	}
+	cm, err = configMaps.Get(context.Background(), peerListName(sw), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data[peerListJSON] != peerListData(sw)[peerListJSON] {
+		t.Errorf("expected the config map updated, got %s", cm.Data[peerListJSON])
+	}
+	for _, pod := range pods {
+		stored, err := f.kubeClient.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stored.Annotations[peerListHashAnnotation]; got != hash {
+			t.Errorf("expected %s annotated with hash %s, got %q", pod.Name, hash, got)
+		}
+	}
+	if !hasEvent(f.events(), ReasonPeerListUpdated) {
+		t.Errorf("expected a %s event", ReasonPeerListUpdated)
+	}
+}
@@ -0,0 +1,94 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// peersServiceName returns the headless Service giving every peer pod a
+// stable DNS name, <pod>.<swarm>-peers.
+func peersServiceName(sw *swarmv1alpha1.Swarm) string {
+	return sw.Name + "-peers"
+}
+
+// peerHost returns the stable DNS name of the peer at index, qualified with
+// the namespace so the leader address peers advertise resolves anywhere in
+// the cluster
+func peerHost(sw *swarmv1alpha1.Swarm, index int) string {
+	return fmt.Sprintf("%s.%s.%s.svc", peerPodName(sw, index), peersServiceName(sw), sw.Namespace)
+}
+
+// initialMembers returns the voting peers a new swarm bootstraps with. Once
+// the swarm has voters new peers start without members and wait to be added
+// through the Pool.
+func initialMembers(sw *swarmv1alpha1.Swarm) []peer.Member {
+	if sw.Status.Voters > 0 {
+		return nil
+	}
+
+	var members []peer.Member
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		if !votingRole(desiredRole(sw, i)) {
+			continue
+		}
+		members = append(members, peer.Member{ID: peerID(sw, i), Address: peerHost(sw, i)})
+	}
+	return members
+}
+
+// addPeerIdentity sets the peer pod DNS name and the environment the peer
+// reads its identity and initial members from on every container.
+func addPeerIdentity(sw *swarmv1alpha1.Swarm, pod *corev1.Pod, index int) {
+	pod.Spec.Hostname = peerPodName(sw, index)
+	pod.Spec.Subdomain = peersServiceName(sw)
+
+	env := []corev1.EnvVar{
+		{
+			Name: peer.EnvPeerID,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", peerIDAnnotation)},
+			},
+		},
+		{
+			Name: peer.EnvPeerAddress,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"},
+			},
+		},
+		{
+			Name:  peer.EnvPeers,
+			Value: peer.FormatMembers(initialMembers(sw)),
+		},
+	}
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].Env = append(pod.Spec.Containers[i].Env, env...)
+	}
+}
+
+// newPeersService returns the headless Service publishing every peer pod,
+// ready or not, as raft members must reach each other to become ready.
+func newPeersService(sw *swarmv1alpha1.Swarm) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            peersServiceName(sw),
+			Namespace:       sw.Namespace,
+			Labels:          map[string]string{swarmLabel: sw.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 map[string]string{swarmLabel: sw.Name},
+			PublishNotReadyAddresses: true,
+		},
+	}
+}
+
+// reconcilePeersService keeps the swarm headless Service
+func (c *Controller) reconcilePeersService(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) error {
+	return c.reconcileService(ctx, key, sw, newPeersService(sw))
+}
@@ -0,0 +1,253 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const testStatusPort = 8080
+
+// peerNetwork routes connections to peer addresses onto httptest servers,
+// connections to any other address are refused
+type peerNetwork struct {
+	t      *testing.T
+	mu     sync.Mutex
+	routes map[string]string
+}
+
+func newPeerNetwork(t *testing.T) *peerNetwork {
+	return &peerNetwork{t: t, routes: map[string]string{}}
+}
+
+// serve starts handler on a test server reached through host
+func (n *peerNetwork) serve(host string, handler http.Handler) *httptest.Server {
+	server := httptest.NewServer(handler)
+	n.t.Cleanup(server.Close)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.routes[host] = server.Listener.Addr().String()
+	return server
+}
+
+// servePod serves handler on the status port of pod
+func (n *peerNetwork) servePod(pod *corev1.Pod, handler http.Handler) *httptest.Server {
+	return n.serve(net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(testStatusPort)), handler)
+}
+
+// drop makes host unreachable
+func (n *peerNetwork) drop(host string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.routes, host)
+}
+
+func (n *peerNetwork) transport() *http.Transport {
+	dialer := &net.Dialer{}
+	return &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			n.mu.Lock()
+			target, ok := n.routes[addr]
+			n.mu.Unlock()
+			if !ok {
+				return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+			}
+			return dialer.DialContext(ctx, network, target)
+		},
+	}
+}
+
+// fakePeer serves the admin API of a swarm peer answering with status and
+// recording the transfers it is asked for. Once it joins a fakeRaft as host
+// the raft answers its status and membership changes.
+type fakePeer struct {
+	mu        sync.Mutex
+	status    peer.Status
+	transfers []peer.TransferRequest
+	raft      *fakeRaft
+	host      string
+}
+
+func newFakePeer(status peer.Status) *fakePeer {
+	return &fakePeer{status: status}
+}
+
+func (p *fakePeer) set(update func(*peer.Status)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	update(&p.status)
+}
+
+// joinRaft makes the peer reached on host a member of raft
+func (p *fakePeer) joinRaft(raft *fakeRaft, host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.raft, p.host = raft, host
+}
+
+func (p *fakePeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.raft != nil && r.URL.Path != peer.TransferPath {
+		p.raft.serve(w, r, p.host)
+		return
+	}
+
+	switch {
+	case r.URL.Path == peer.StatusPath && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p.status)
+	case r.URL.Path == peer.TransferPath && r.Method == http.MethodPost:
+		var transfer peer.TransferRequest
+		if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.transfers = append(p.transfers, transfer)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, fmt.Sprintf("unexpected %s %s", r.Method, r.URL.Path), http.StatusNotFound)
+	}
+}
+
+// requestedTransfers returns the transfers the peer was asked for
+func (p *fakePeer) requestedTransfers() []peer.TransferRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]peer.TransferRequest(nil), p.transfers...)
+}
+
+// peerFixture runs sw on newFixture with a running pod per replica, each
+// serving a fakePeer. The Status.Leader pod answers as leader and the others
+// as followers, on Status.Term or term 1 when unset. Pods are labeled with
+// roles by index when given.
+type peerFixture struct {
+	*fixture
+	sw    *swarmv1alpha1.Swarm
+	pods  []*corev1.Pod
+	peers []*fakePeer
+}
+
+func newPeerFixture(t *testing.T, pool Pool, sw *swarmv1alpha1.Swarm, roles ...string) *peerFixture {
+	term := sw.Status.Term
+	if term == 0 {
+		term = 1
+	}
+	var pods []*corev1.Pod
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		pod := newTestPeer(sw, i)
+		if i < len(roles) {
+			pod.Labels[peerRoleLabel] = roles[i]
+		}
+		pods = append(pods, pod)
+	}
+
+	f := &peerFixture{
+		fixture: newFixture(t, pool, []*swarmv1alpha1.Swarm{sw}, pods),
+		sw:      sw,
+		pods:    pods,
+	}
+	for i, pod := range pods {
+		state := "Follower"
+		if pod.Name == sw.Status.Leader {
+			state = peerStateLeader
+		}
+		p := newFakePeer(peer.Status{ID: peerID(sw, i), State: state, Term: term})
+		f.fixture.peers.servePod(pod, p)
+		f.peers = append(f.peers, p)
+	}
+	return f
+}
+
+// withStatusEndpoint enables leader discovery on the test status port
+func withStatusEndpoint(sw *swarmv1alpha1.Swarm) *swarmv1alpha1.Swarm {
+	sw.Spec.Endpoints.Status = &swarmv1alpha1.StatusEndpoint{
+		HTTPEndpoint: swarmv1alpha1.HTTPEndpoint{Port: testStatusPort, Path: peer.StatusPath},
+	}
+	return sw
+}
+
+// withTransferEndpoint enables leadership transfers on the test status port
+func withTransferEndpoint(sw *swarmv1alpha1.Swarm, timeoutSeconds int32) *swarmv1alpha1.Swarm {
+	sw.Spec.Endpoints.Transfer = &swarmv1alpha1.TransferEndpoint{
+		HTTPEndpoint:   swarmv1alpha1.HTTPEndpoint{Port: testStatusPort, Path: peer.TransferPath},
+		TimeoutSeconds: timeoutSeconds,
+	}
+	return withStatusEndpoint(sw)
+}
+
+// recordingPool records the Pool changes it is asked for, failing them with
+// err when set, and keeps the membership a leader would report
+type recordingPool struct {
+	mu       sync.Mutex
+	err      error
+	added    []string
+	removed  []string
+	promoted []string
+	calls    []string
+	members  map[string]bool
+}
+
+func (p *recordingPool) Add(_ context.Context, _ *swarmv1alpha1.Swarm, _ int, id string, _ net.IP) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.added = append(p.added, id)
+	p.calls = append(p.calls, "add "+id)
+	if p.err != nil {
+		return p.err
+	}
+	if p.members == nil {
+		p.members = map[string]bool{}
+	}
+	p.members[id] = true
+	return nil
+}
+
+func (p *recordingPool) Remove(_ context.Context, _ *swarmv1alpha1.Swarm, _ int, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removed = append(p.removed, id)
+	p.calls = append(p.calls, "remove "+id)
+	if p.err != nil {
+		return p.err
+	}
+	delete(p.members, id)
+	return nil
+}
+
+func (p *recordingPool) Promote(_ context.Context, _ *swarmv1alpha1.Swarm, _ int, id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.promoted = append(p.promoted, id)
+	p.calls = append(p.calls, "promote "+id)
+	return p.err
+}
+
+func (p *recordingPool) IsMember(_ context.Context, _ *swarmv1alpha1.Swarm, id string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.members[id], nil
+}
+
+// drain returns the calls made so far
+func (p *recordingPool) drain() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	calls := p.calls
+	p.calls = nil
+	return calls
+}
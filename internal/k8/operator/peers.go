@@ -0,0 +1,171 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// peerIndexLabel holds the peer index of a swarm pod, peer pods are named by
+// index so a recreated peer keeps its identity.
+const peerIndexLabel = swarmLabel + "-peer-index"
+
+// peerPodName returns the pod name of the swarm peer at index
+func peerPodName(sw *swarmv1alpha1.Swarm, index int) string {
+	return fmt.Sprintf("%s-%d", sw.Name, index)
+}
+
+// reconcilePeers converges the owned pods of the swarm to one pod per peer
+// index below Spec.Replicas, creating missing peers and deleting the ones
+// above.
+func (c *Controller) reconcilePeers(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, claimed []*corev1.Pod) error {
+	owned := make(map[string]*corev1.Pod, len(claimed))
+	for _, pod := range claimed {
+		owned[pod.Name] = pod
+	}
+
+	var creates []*corev1.Pod
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		name := peerPodName(sw, i)
+		if _, ok := owned[name]; ok {
+			delete(owned, name)
+			continue
+		}
+		if _, err := c.podLister.Pods(sw.Namespace).Get(name); err == nil {
+			// the name is taken by a pod the Swarm does not own
+			klog.Infof("instance %s: pod %s exists but is not owned by the swarm", key, name)
+			continue
+		}
+		pod, err := c.newPeerPod(ctx, key, sw, i)
+		if err != nil {
+			return err
+		}
+		creates = append(creates, pod)
+	}
+
+	// owned pods left are above the desired replicas, the leader hands
+	// leadership over and every peer leaves the Pool before going away
+	var errs []error
+	var deletes []*corev1.Pod
+	for _, pod := range owned {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		ok, err := c.handOverLeadership(ctx, key, sw, pod)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := c.leavePool(ctx, sw, pod); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deletes = append(deletes, pod)
+	}
+	sort.Slice(deletes, func(i, j int) bool {
+		return deletes[i].Name < deletes[j].Name
+	})
+
+	c.expectations.expectCreations(key, len(creates))
+	for _, pod := range creates {
+		if err := c.createPeer(ctx, key, sw, pod); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.expectations.expectDeletions(key, len(deletes))
+	for _, pod := range deletes {
+		if err := c.deletePeer(ctx, key, sw, pod); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// leavePool removes the peer running on pod from the Pool
+func (c *Controller) leavePool(ctx context.Context, sw *swarmv1alpha1.Swarm, pod *corev1.Pod) error {
+	idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+	if err != nil {
+		return fmt.Errorf("pod %s has no peer index: %v", pod.Name, err)
+	}
+	id := pod.Annotations[peerIDAnnotation]
+	if id == "" {
+		id = peerID(sw, idx)
+	}
+
+	if err := c.pool.Remove(ctx, sw, idx, id); err != nil {
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error removing peer %s from pool: %v", id, err)
+		return err
+	}
+	return nil
+}
+
+func (c *Controller) createPeer(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pod *corev1.Pod) error {
+	_, err := c.kubeClientset.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		// the creation will never be observed, lower the expectation
+		c.expectations.creationObserved(key)
+		if errors.IsAlreadyExists(err) {
+			// the cache is behind, its add event requeues us
+			return nil
+		}
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error creating peer pod %s: %v", pod.Name, err)
+		return err
+	}
+
+	klog.Infof("instance %s: pod launched: name=%s", key, pod.Name)
+	c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonPeerCreated, "Created peer pod %s", pod.Name)
+	return nil
+}
+
+func (c *Controller) deletePeer(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pod *corev1.Pod) error {
+	err := c.kubeClientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	if err != nil {
+		// the deletion will never be observed, lower the expectation
+		c.expectations.deletionObserved(key)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error deleting peer pod %s: %v", pod.Name, err)
+		return err
+	}
+
+	klog.Infof("instance %s: pod deleted: name=%s", key, pod.Name)
+	return nil
+}
+
+// activePods filters out pods terminated or being deleted
+func activePods(pods []*corev1.Pod) []*corev1.Pod {
+	var active []*corev1.Pod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		active = append(active, pod)
+	}
+	return active
+}
+
+// peerPods filters out pods whose peer index is not below Spec.Replicas
+func peerPods(sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) []*corev1.Pod {
+	var peers []*corev1.Pod
+	for _, pod := range pods {
+		idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+		if err != nil || idx < 0 || idx >= sw.Spec.Replicas {
+			continue
+		}
+		peers = append(peers, pod)
+	}
+	return peers
+}
@@ -0,0 +1,138 @@
+package operator
+
+import (
+	"fmt"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	nodeTopologyKey = corev1.LabelHostname
+	zoneTopologyKey = corev1.LabelTopologyZone
+)
+
+// addPeerPlacement translates the swarm placement policy into pod scheduling
+// constraints, peers of the same swarm repel each other. They are merged into
+// the constraints the pod template sets, placement node selector labels win.
+func addPeerPlacement(sw *swarmv1alpha1.Swarm, pod *corev1.Pod) {
+	placement := sw.Spec.Placement
+	if placement == nil {
+		return
+	}
+
+	peers := &metav1.LabelSelector{MatchLabels: map[string]string{swarmLabel: sw.Name}}
+	term := corev1.PodAffinityTerm{
+		LabelSelector: peers,
+		TopologyKey:   nodeTopologyKey,
+	}
+	switch placement.NodeAntiAffinity {
+	case swarmv1alpha1.RequiredAntiAffinity:
+		antiAffinity := podAntiAffinity(pod)
+		antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, term)
+	case swarmv1alpha1.PreferredAntiAffinity:
+		antiAffinity := podAntiAffinity(pod)
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.WeightedPodAffinityTerm{Weight: 100, PodAffinityTerm: term})
+	}
+
+	if spread := placement.ZoneSpread; spread != nil {
+		whenUnsatisfiable := spread.WhenUnsatisfiable
+		if whenUnsatisfiable == "" {
+			whenUnsatisfiable = corev1.ScheduleAnyway
+		}
+		maxSkew := spread.MaxSkew
+		if maxSkew < 1 {
+			maxSkew = 1
+		}
+		pod.Spec.TopologySpreadConstraints = append(pod.Spec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+			MaxSkew:           maxSkew,
+			TopologyKey:       zoneTopologyKey,
+			WhenUnsatisfiable: whenUnsatisfiable,
+			LabelSelector:     peers,
+		})
+	}
+
+	if len(placement.NodeSelector) > 0 {
+		nodeSelector := make(map[string]string, len(pod.Spec.NodeSelector)+len(placement.NodeSelector))
+		for k, v := range pod.Spec.NodeSelector {
+			nodeSelector[k] = v
+		}
+		for k, v := range placement.NodeSelector {
+			nodeSelector[k] = v
+		}
+		pod.Spec.NodeSelector = nodeSelector
+	}
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, placement.Tolerations...)
+}
+
+// podAntiAffinity returns the pod anti affinity, set empty when the pod has
+// none yet
+func podAntiAffinity(pod *corev1.Pod) *corev1.PodAntiAffinity {
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.PodAntiAffinity == nil {
+		pod.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	}
+	return pod.Spec.Affinity.PodAntiAffinity
+}
+
+// updatePlacementStatus records how the scheduled peers are distributed over
+// nodes and zones, raising a condition when one zone holds a quorum.
+func (c *Controller) updatePlacementStatus(sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) {
+	nodes := map[string]int32{}
+	zones := map[string]int32{}
+	votingZones := map[string]int{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		nodes[pod.Spec.NodeName]++
+
+		node, err := c.nodeLister.Get(pod.Spec.NodeName)
+		if err != nil {
+			klog.V(4).Infof("node %s of pod %s not found: %v", pod.Spec.NodeName, pod.Name, err)
+			continue
+		}
+		if zone, ok := node.Labels[zoneTopologyKey]; ok {
+			zones[zone]++
+			if votingRole(pod.Labels[peerRoleLabel]) {
+				votingZones[zone]++
+			}
+		}
+	}
+
+	sw.Status.Nodes = nil
+	if len(nodes) > 0 {
+		sw.Status.Nodes = nodes
+	}
+	sw.Status.Zones = nil
+	if len(zones) > 0 {
+		sw.Status.Zones = zones
+	}
+
+	size := votingSize(sw)
+	condition := metav1.Condition{
+		Type:               swarmv1alpha1.ConditionZoneQuorum,
+		Status:             metav1.ConditionFalse,
+		Reason:             "PeersSpread",
+		Message:            "No zone holds a quorum of peers",
+		ObservedGeneration: sw.Generation,
+	}
+	// only one zone can hold a majority of the voting peers
+	for zone, count := range votingZones {
+		if count < quorum(size) {
+			continue
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "QuorumInSingleZone"
+		condition.Message = fmt.Sprintf("Zone %s holds %d voting peers, a quorum of %d", zone, count, size)
+		if !meta.IsStatusConditionTrue(sw.Status.Conditions, swarmv1alpha1.ConditionZoneQuorum) {
+			c.recorder.Event(sw, corev1.EventTypeWarning, condition.Reason, condition.Message)
+		}
+	}
+	meta.SetStatusCondition(&sw.Status.Conditions, condition)
+}
@@ -0,0 +1,76 @@
+package operator
+
+import (
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPeerPlacementMergesTemplateConstraints(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	userTerm := corev1.PodAffinityTerm{TopologyKey: "rack"}
+	nodeAffinity := &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpIn, Values: []string{"ssd"}}},
+		}}},
+	}
+	sw.Spec.Template = &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "peer", Image: "peer:1"}},
+		Affinity: &corev1.Affinity{
+			NodeAffinity:    nodeAffinity,
+			PodAntiAffinity: &corev1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{userTerm}},
+		},
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{MaxSkew: 2, TopologyKey: "rack", WhenUnsatisfiable: corev1.DoNotSchedule}},
+		NodeSelector:              map[string]string{"arch": "arm64", "pool": "default"},
+	}}
+	sw.Spec.Placement = &swarmv1alpha1.Placement{
+		NodeAntiAffinity: swarmv1alpha1.RequiredAntiAffinity,
+		ZoneSpread:       &swarmv1alpha1.ZoneSpread{MaxSkew: 1},
+		NodeSelector:     map[string]string{"pool": "consensus"},
+	}
+
+	pod, err := newPodForCR(sw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		t.Fatalf("expected the template node affinity kept, got %v", affinity)
+	}
+	terms := affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(terms) != 2 || terms[0].TopologyKey != "rack" || terms[1].TopologyKey != nodeTopologyKey {
+		t.Errorf("expected the peer anti affinity appended to the template one, got %v", terms)
+	}
+	spread := pod.Spec.TopologySpreadConstraints
+	if len(spread) != 2 || spread[0].TopologyKey != "rack" || spread[1].TopologyKey != zoneTopologyKey {
+		t.Errorf("expected the zone spread appended to the template one, got %v", spread)
+	}
+	if got := pod.Spec.NodeSelector; len(got) != 2 || got["arch"] != "arm64" || got["pool"] != "consensus" {
+		t.Errorf("expected node selectors merged with placement winning, got %v", got)
+	}
+
+	// the template itself is left untouched
+	template := sw.Spec.Template.Spec
+	if len(template.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 || len(template.TopologySpreadConstraints) != 1 || template.NodeSelector["pool"] != "default" {
+		t.Errorf("expected the swarm template unchanged, got %v", template)
+	}
+}
+
+func TestPeerPlacementPreferredAntiAffinity(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	sw.Spec.Placement = &swarmv1alpha1.Placement{NodeAntiAffinity: swarmv1alpha1.PreferredAntiAffinity}
+
+	pod, err := newPodForCR(sw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preferred := pod.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+	if len(preferred) != 1 || preferred[0].PodAffinityTerm.LabelSelector.MatchLabels[swarmLabel] != "foo" {
+		t.Errorf("expected peers of foo repelled, got %v", preferred)
+	}
+	if pod.Spec.NodeSelector != nil || pod.Spec.TopologySpreadConstraints != nil {
+		t.Errorf("expected no other constraint, got %v and %v", pod.Spec.NodeSelector, pod.Spec.TopologySpreadConstraints)
+	}
+}
@@ -0,0 +1,97 @@
+package operator
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	kubeinformers "k8s.io/client-go/informers"
+	corev1informer "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1lister "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SwarmLabeled restricts list and watch calls to objects labeled as swarm
+// members, to be used as informer factory tweak.
+func SwarmLabeled(options *metav1.ListOptions) {
+	options.LabelSelector = swarmLabel
+}
+
+type podInformer struct {
+	ctx     context.Context
+	factory kubeinformers.SharedInformerFactory
+}
+
+// NewSwarmPodInformer returns a pod informer registered on factory which only
+// lists and watches pods labeled as swarm members, cached pods are stripped
+// down to the fields the controller reads. As cached pods are partial they
+// must never be sent back on updates, patch them instead. Orphans matching a
+// custom swarm selector without the swarm label are listed when claiming.
+func NewSwarmPodInformer(ctx context.Context, factory kubeinformers.SharedInformerFactory) corev1informer.PodInformer {
+	return &podInformer{ctx: ctx, factory: factory}
+}
+
+func (p *podInformer) Informer() cache.SharedIndexInformer {
+	return p.factory.InformerFor(&corev1.Pod{}, p.newInformer)
+}
+
+func (p *podInformer) Lister() corev1lister.PodLister {
+	return corev1lister.NewPodLister(p.Informer().GetIndexer())
+}
+
+func (p *podInformer) newInformer(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				SwarmLabeled(&options)
+				list, err := client.CoreV1().Pods(metav1.NamespaceAll).List(p.ctx, options)
+				if err != nil {
+					return nil, err
+				}
+				for i := range list.Items {
+					stripPod(&list.Items[i])
+				}
+				return list, nil
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				SwarmLabeled(&options)
+				w, err := client.CoreV1().Pods(metav1.NamespaceAll).Watch(p.ctx, options)
+				if err != nil {
+					return nil, err
+				}
+				return watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+					if pod, ok := e.Object.(*corev1.Pod); ok {
+						stripPod(pod)
+					}
+					return e, true
+				}), nil
+			},
+		},
+		&corev1.Pod{},
+		resync,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+}
+
+// stripPod drops the pod fields the controller never reads, keeping metadata,
+// node placement and the status summary.
+func stripPod(pod *corev1.Pod) {
+	pod.ManagedFields = nil
+	pod.Spec = corev1.PodSpec{
+		NodeName: pod.Spec.NodeName,
+	}
+	pod.Status = corev1.PodStatus{
+		Phase:      pod.Status.Phase,
+		Conditions: pod.Status.Conditions,
+		Message:    pod.Status.Message,
+		Reason:     pod.Status.Reason,
+		HostIP:     pod.Status.HostIP,
+		PodIP:      pod.Status.PodIP,
+		PodIPs:     pod.Status.PodIPs,
+		StartTime:  pod.Status.StartTime,
+	}
+}
@@ -0,0 +1,87 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	corev1informer "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	benchUnrelatedPods = 5000
+	benchSwarmPods     = 10
+)
+
+// benchPod returns a pod shaped as workloads usually are, with a few
+// containers, env and volumes the controller never reads
+func benchPod(name string, labels map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    labels,
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply, FieldsV1: &metav1.FieldsV1{Raw: make([]byte, 512)}},
+			},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+	}
+	for i := 0; i < 3; i++ {
+		container := corev1.Container{Name: fmt.Sprintf("c%d", i), Image: "registry.local/workload:1.0"}
+		for j := 0; j < 10; j++ {
+			container.Env = append(container.Env, corev1.EnvVar{Name: fmt.Sprintf("VAR_%d", j), Value: "some configuration value"})
+		}
+		pod.Spec.Containers = append(pod.Spec.Containers, container)
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: fmt.Sprintf("v%d", i)})
+	}
+	return pod
+}
+
+// BenchmarkPodInformer syncs a pod informer on a cluster running thousands of
+// pods unrelated to swarms, watching every pod or swarm labeled pods only. It
+// reports how many pods end up cached. The fake clientset filters labels
+// after copying every pod, list allocations are lower against a real API
+// server.
+func BenchmarkPodInformer(b *testing.B) {
+	var objects []runtime.Object
+	for i := 0; i < benchUnrelatedPods; i++ {
+		objects = append(objects, benchPod(fmt.Sprintf("unrelated-%d", i), map[string]string{"app": "unrelated"}))
+	}
+	for i := 0; i < benchSwarmPods; i++ {
+		objects = append(objects, benchPod(fmt.Sprintf("swarm-%d", i), map[string]string{swarmLabel: "swarm"}))
+	}
+	client := fake.NewSimpleClientset(objects...)
+
+	run := func(b *testing.B, informerFor func(context.Context, kubeinformers.SharedInformerFactory) corev1informer.PodInformer) {
+		b.ReportAllocs()
+		var cached int
+		for i := 0; i < b.N; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			factory := kubeinformers.NewSharedInformerFactory(client, 0)
+			informer := informerFor(ctx, factory).Informer()
+			factory.Start(ctx.Done())
+			if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+				b.Fatal("pod informer did not sync")
+			}
+			cached = len(informer.GetStore().List())
+			cancel()
+		}
+		b.ReportMetric(float64(cached), "pods")
+	}
+
+	b.Run("all", func(b *testing.B) {
+		run(b, func(_ context.Context, factory kubeinformers.SharedInformerFactory) corev1informer.PodInformer {
+			return factory.Core().V1().Pods()
+		})
+	})
+	b.Run("swarm", func(b *testing.B) {
+		run(b, NewSwarmPodInformer)
+	})
+}
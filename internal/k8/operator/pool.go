@@ -1,17 +1,30 @@
 package operator
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
 	"net"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
 )
 
-type pool struct {}
+type pool struct{}
 
 func NewPool() *pool {
 	return &pool{}
 }
 
-func (p *pool) Add(idx int, id string, add net.IP) error {
-	log.Infof("add idx %d ID %s ip %s", idx, id, add.String())
+func (p *pool) Add(_ context.Context, sw *swarmv1alpha1.Swarm, idx int, id string, add net.IP) error {
+	log.Infof("swarm %s add idx %d ID %s ip %s", sw.Name, idx, id, add.String())
+	return nil
+}
+
+func (p *pool) Promote(_ context.Context, sw *swarmv1alpha1.Swarm, idx int, id string) error {
+	log.Infof("swarm %s promote idx %d ID %s", sw.Name, idx, id)
 	return nil
-}
\ No newline at end of file
+}
+
+func (p *pool) Remove(_ context.Context, sw *swarmv1alpha1.Swarm, idx int, id string) error {
+	log.Infof("swarm %s remove idx %d ID %s", sw.Name, idx, id)
+	return nil
+}
@@ -0,0 +1,119 @@
+package operator
+
+import (
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UpdatePredicate reports whether an informer update is relevant enough to be
+// reconciled, no-op updates such as periodic resyncs or the controller's own
+// status writes are filtered out.
+type UpdatePredicate func(old, new interface{}) bool
+
+// SwarmUpdatePredicate accepts swarm updates changing its spec, metadata or
+// deletion state, status only updates do not bump the generation.
+func SwarmUpdatePredicate() UpdatePredicate {
+	return and(resourceVersionChanged, or(generationChanged, labelsChanged, annotationsChanged, deletionChanged))
+}
+
+// PodUpdatePredicate accepts pod updates changing its metadata, ownership or
+// the status fields the controller reads.
+func PodUpdatePredicate() UpdatePredicate {
+	return and(resourceVersionChanged, or(labelsChanged, annotationsChanged, ownerChanged, deletionChanged, podStatusChanged))
+}
+
+func and(predicates ...UpdatePredicate) UpdatePredicate {
+	return func(old, new interface{}) bool {
+		for _, p := range predicates {
+			if !p(old, new) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func or(predicates ...UpdatePredicate) UpdatePredicate {
+	return func(old, new interface{}) bool {
+		for _, p := range predicates {
+			if p(old, new) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// resourceVersionChanged filters resync updates, delivering the same object
+func resourceVersionChanged(old, new interface{}) bool {
+	o, n, ok := accessors(old, new)
+	return !ok || o.GetResourceVersion() != n.GetResourceVersion()
+}
+
+func generationChanged(old, new interface{}) bool {
+	o, n, ok := accessors(old, new)
+	return !ok || o.GetGeneration() != n.GetGeneration()
+}
+
+func labelsChanged(old, new interface{}) bool {
+	o, n, ok := accessors(old, new)
+	return !ok || !reflect.DeepEqual(o.GetLabels(), n.GetLabels())
+}
+
+func annotationsChanged(old, new interface{}) bool {
+	o, n, ok := accessors(old, new)
+	return !ok || !reflect.DeepEqual(o.GetAnnotations(), n.GetAnnotations())
+}
+
+func ownerChanged(old, new interface{}) bool {
+	o, n, ok := accessors(old, new)
+	return !ok || !reflect.DeepEqual(o.GetOwnerReferences(), n.GetOwnerReferences())
+}
+
+func deletionChanged(old, new interface{}) bool {
+	o, n, ok := accessors(old, new)
+	return !ok || (o.GetDeletionTimestamp() == nil) != (n.GetDeletionTimestamp() == nil)
+}
+
+func podStatusChanged(old, new interface{}) bool {
+	o, ok := old.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+	n, ok := new.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+
+	return o.Status.Phase != n.Status.Phase ||
+		o.Status.PodIP != n.Status.PodIP ||
+		o.Spec.NodeName != n.Spec.NodeName ||
+		podReady(o) != podReady(n)
+}
+
+// podReady reports whether the pod Ready condition is true
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// accessors returns both objects metadata, ok is false on unexpected types so
+// that the update is never dropped by mistake.
+func accessors(old, new interface{}) (metav1.Object, metav1.Object, bool) {
+	o, err := meta.Accessor(old)
+	if err != nil {
+		return nil, nil, false
+	}
+	n, err := meta.Accessor(new)
+	if err != nil {
+		return nil, nil, false
+	}
+	return o, n, true
+}
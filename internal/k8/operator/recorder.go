@@ -0,0 +1,58 @@
+package operator
+
+import (
+	swarmScheme "github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned/scheme"
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Event reasons recorded against Swarms, so that describing a Swarm tells
+// its lifecycle story.
+const (
+	ReasonPeerCreated          = "PeerCreated"
+	ReasonPeerDeleted          = "PeerDeleted"
+	ReasonPeerFailed           = "PeerFailed"
+	ReasonPeerUpdating         = "PeerUpdating"
+	ReasonPeerUpdated          = "PeerUpdated"
+	ReasonPeerReplaced         = "PeerReplaced"
+	ReasonPeerPromoted         = "PeerPromoted"
+	ReasonLeaderElected        = "LeaderElected"
+	ReasonLeaderTransfer       = "LeaderTransfer"
+	ReasonLeaderTransferFailed = "LeaderTransferFailed"
+	ReasonReplacementBlocked   = "ReplacementBlocked"
+	ReasonScalingStarted       = "ScalingStarted"
+	ReasonScalingCompleted     = "ScalingCompleted"
+	ReasonMembershipChanged    = "MembershipChanged"
+	ReasonPeerListUpdated      = "PeerListUpdated"
+	ReasonAddressPoolExhausted = "AddressPoolExhausted"
+	ReasonPeerCollision        = "PeerCollision"
+	ReasonInvalidSpec          = "InvalidSpec"
+	ReasonQuorumLost           = "QuorumLost"
+	ReasonPodAdopted           = "PodAdopted"
+	ReasonPodReleased          = "PodReleased"
+	ReasonPodIgnored           = "PodControlledByOther"
+)
+
+// NewEventRecorder returns a recorder publishing Events to the API server on
+// behalf of component.
+func NewEventRecorder(kubeClientset kubernetes.Interface, component string) record.EventRecorder {
+	// Add swarm-controller types to the default Kubernetes Scheme so Events can be
+	// logged for swarm-controller types.
+	utilruntime.Must(swarmScheme.AddToScheme(scheme.Scheme))
+	klog.V(4).Info("Creating event broadcaster")
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClientset.CoreV1().Events("")})
+
+	return eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// quorum returns the majority of a swarm of size peers
+func quorum(size int) int {
+	return size/2 + 1
+}
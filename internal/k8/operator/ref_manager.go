@@ -0,0 +1,152 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// podRefManager decides pod ownership for a Swarm as the upstream
+// ControllerRefManager does: matching orphans are adopted, owned pods no
+// longer matching the selector are released and pods controlled by another
+// owner are never touched. Orphans labeled for another Swarm are refused,
+// adopted pods get the swarm label so the pod cache sees them.
+type podRefManager struct {
+	client   kubernetes.Interface
+	recorder record.EventRecorder
+	swarm    *swarmv1alpha1.Swarm
+	selector labels.Selector
+	// canAdopt rechecks against the API that the Swarm is still alive before
+	// the first adoption, the cached copy might be stale.
+	canAdopt func(ctx context.Context) error
+	adoptErr error
+	checked  bool
+}
+
+func newPodRefManager(client kubernetes.Interface, recorder record.EventRecorder, sw *swarmv1alpha1.Swarm, selector labels.Selector, canAdopt func(ctx context.Context) error) *podRefManager {
+	return &podRefManager{
+		client:   client,
+		recorder: recorder,
+		swarm:    sw,
+		selector: selector,
+		canAdopt: canAdopt,
+	}
+}
+
+// claimPods returns the pods owned by the Swarm once adoptions and releases
+// have been applied.
+func (m *podRefManager) claimPods(ctx context.Context, pods []*corev1.Pod) ([]*corev1.Pod, error) {
+	var claimed []*corev1.Pod
+	var errs []error
+	for _, pod := range pods {
+		ok, err := m.claimPod(ctx, pod)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			claimed = append(claimed, pod)
+		}
+	}
+
+	if len(errs) > 0 {
+		return claimed, fmt.Errorf("claiming pods of swarm %s/%s: %v", m.swarm.Namespace, m.swarm.Name, errs)
+	}
+
+	return claimed, nil
+}
+
+func (m *podRefManager) claimPod(ctx context.Context, pod *corev1.Pod) (bool, error) {
+	match := m.selector.Matches(labels.Set(pod.Labels))
+
+	if ref := metav1.GetControllerOf(pod); ref != nil {
+		if ref.UID != m.swarm.UID {
+			if match {
+				klog.V(4).Infof("pod %s/%s matches swarm %s but is controlled by %s %s", pod.Namespace, pod.Name, m.swarm.Name, ref.Kind, ref.Name)
+				m.recorder.Eventf(m.swarm, corev1.EventTypeWarning, ReasonPodIgnored, "Pod %s is controlled by %s %s", pod.Name, ref.Kind, ref.Name)
+			}
+			return false, nil
+		}
+
+		if match {
+			return true, nil
+		}
+
+		// owned but not selected anymore, a Swarm being deleted leaves its
+		// pods to the garbage collector
+		if m.swarm.DeletionTimestamp != nil {
+			return false, nil
+		}
+
+		if err := m.releasePod(ctx, pod); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	// orphan pod
+	if !match || m.swarm.DeletionTimestamp != nil || pod.DeletionTimestamp != nil {
+		return false, nil
+	}
+	if owner, ok := pod.Labels[swarmLabel]; ok && owner != m.swarm.Name {
+		klog.V(4).Infof("pod %s/%s matches swarm %s but is labeled for swarm %s", pod.Namespace, pod.Name, m.swarm.Name, owner)
+		return false, nil
+	}
+
+	if err := m.adoptPod(ctx, pod); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (m *podRefManager) adoptPod(ctx context.Context, pod *corev1.Pod) error {
+	if !m.checked {
+		m.adoptErr = m.canAdopt(ctx)
+		m.checked = true
+	}
+	if m.adoptErr != nil {
+		return fmt.Errorf("can't adopt pod %s/%s: %v", pod.Namespace, pod.Name, m.adoptErr)
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q},"ownerReferences":[{"apiVersion":%q,"kind":"Swarm","name":%q,"uid":%q,"controller":true,"blockOwnerDeletion":true}],"uid":%q}}`,
+		swarmLabel, m.swarm.Name, swarmv1alpha1.SchemeGroupVersion.String(), m.swarm.Name, m.swarm.UID, pod.UID)
+	if _, err := m.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return err
+	}
+
+	klog.Infof("swarm %s/%s adopted pod %s", m.swarm.Namespace, m.swarm.Name, pod.Name)
+	m.recorder.Eventf(m.swarm, corev1.EventTypeNormal, ReasonPodAdopted, "Adopted orphan pod %s", pod.Name)
+	return nil
+}
+
+func (m *podRefManager) releasePod(ctx context.Context, pod *corev1.Pod) error {
+	patch := fmt.Sprintf(`{"metadata":{"ownerReferences":[{"$patch":"delete","uid":%q}],"uid":%q}}`, m.swarm.UID, pod.UID)
+	_, err := m.client.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	// pod gone, or deleted and recreated with another uid, nothing to release
+	if err != nil && !errors.IsNotFound(err) && !errors.IsInvalid(err) {
+		return err
+	}
+
+	klog.Infof("swarm %s/%s released pod %s", m.swarm.Namespace, m.swarm.Name, pod.Name)
+	m.recorder.Eventf(m.swarm, corev1.EventTypeNormal, ReasonPodReleased, "Released pod %s not matching selector", pod.Name)
+	return nil
+}
+
+// swarmSelector returns the Swarm pod selector, defaulting to its swarm label
+func swarmSelector(sw *swarmv1alpha1.Swarm) (labels.Selector, error) {
+	if sw.Spec.Selector == nil {
+		return labels.SelectorFromSet(labels.Set{swarmLabel: sw.Name}), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(sw.Spec.Selector)
+}
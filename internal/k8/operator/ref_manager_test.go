@@ -0,0 +1,155 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// orphanPod returns a pod without controller labeled with podLabels
+func orphanPod(name string, podLabels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+			UID:       types.UID(name + "-uid"),
+			Labels:    podLabels,
+		},
+	}
+}
+
+// claim runs the swarm pod claim over the cached pods of its namespace, as
+// a reconcile does
+func (f *fixture) claim(sw *swarmv1alpha1.Swarm) []string {
+	f.t.Helper()
+	selector, err := swarmSelector(sw)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	pods, err := f.controller.podLister.Pods(sw.Namespace).List(labels.Everything())
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	claimed, err := f.controller.claimPods(context.Background(), sw, selector, pods)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range claimed {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+// controllerOf reads pod back from the API and returns its controller name
+func (f *fixture) controllerOf(pod *corev1.Pod) (string, *corev1.Pod) {
+	f.t.Helper()
+	fresh, err := f.kubeClient.CoreV1().Pods(pod.Namespace).Get(context.Background(), pod.Name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	if ref := metav1.GetControllerOf(fresh); ref != nil {
+		return ref.Name, fresh
+	}
+	return "", fresh
+}
+
+func TestClaimPodsAdoptsLabeledOrphan(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	orphan := orphanPod("foo-0", map[string]string{swarmLabel: "foo"})
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, []*corev1.Pod{orphan})
+
+	if claimed := f.claim(sw); len(claimed) != 1 || claimed[0] != "foo-0" {
+		t.Fatalf("expected foo-0 claimed, got %v", claimed)
+	}
+	if owner, _ := f.controllerOf(orphan); owner != "foo" {
+		t.Errorf("expected foo-0 controlled by foo, got %q", owner)
+	}
+	if !hasEvent(f.events(), ReasonPodAdopted) {
+		t.Errorf("expected a %s event", ReasonPodAdopted)
+	}
+}
+
+func TestClaimPodsAdoptsUnlabeledOrphanMatchingSelector(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	sw.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+	// not swarm labeled, the pod cache never sees it
+	orphan := orphanPod("db-0", map[string]string{"app": "db"})
+	other := orphanPod("web-0", map[string]string{"app": "web"})
+	for _, pod := range []*corev1.Pod{orphan, other} {
+		if err := f.kubeClient.Tracker().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if claimed := f.claim(sw); len(claimed) != 1 || claimed[0] != "db-0" {
+		t.Fatalf("expected db-0 claimed, got %v", claimed)
+	}
+	owner, adopted := f.controllerOf(orphan)
+	if owner != "foo" || adopted.Labels[swarmLabel] != "foo" {
+		t.Errorf("expected db-0 controlled and labeled by foo, got %q labels %v", owner, adopted.Labels)
+	}
+	if owner, _ := f.controllerOf(other); owner != "" {
+		t.Errorf("expected web-0 left alone, got controller %q", owner)
+	}
+}
+
+func TestClaimPodsRefusesPodLabeledForAnotherSwarm(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	sw.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	bar := newTestSwarm("bar", 1)
+	orphan := orphanPod("bar-0", map[string]string{"app": "db", swarmLabel: "bar"})
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw, bar}, []*corev1.Pod{orphan})
+
+	if claimed := f.claim(sw); len(claimed) != 0 {
+		t.Fatalf("expected no pod claimed, got %v", claimed)
+	}
+	if owner, _ := f.controllerOf(orphan); owner != "" {
+		t.Errorf("expected bar-0 left to bar, got controller %q", owner)
+	}
+	if claimed := f.claim(bar); len(claimed) != 1 {
+		t.Errorf("expected bar to adopt its pod, got %v", claimed)
+	}
+}
+
+func TestClaimPodsReleasesUnselectedPod(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	sw.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{swarmLabel: "foo", "tier": "data"}}
+	owned := orphanPod("foo-0", map[string]string{swarmLabel: "foo", "tier": "cache"})
+	owned.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, []*corev1.Pod{owned})
+
+	if claimed := f.claim(sw); len(claimed) != 0 {
+		t.Fatalf("expected the pod released, got %v", claimed)
+	}
+	if owner, _ := f.controllerOf(owned); owner != "" {
+		t.Errorf("expected foo-0 without controller, got %q", owner)
+	}
+	if !hasEvent(f.events(), ReasonPodReleased) {
+		t.Errorf("expected a %s event", ReasonPodReleased)
+	}
+}
+
+func TestClaimPodsIgnoresPodOwnedByAnotherController(t *testing.T) {
+	sw := newTestSwarm("foo", 1)
+	pod := orphanPod("foo-0", map[string]string{swarmLabel: "foo"})
+	controller := true
+	pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs", UID: "rs-uid", Controller: &controller}}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, []*corev1.Pod{pod})
+
+	if claimed := f.claim(sw); len(claimed) != 0 {
+		t.Fatalf("expected no pod claimed, got %v", claimed)
+	}
+	if owner, _ := f.controllerOf(pod); owner != "rs" {
+		t.Errorf("expected foo-0 kept by rs, got %q", owner)
+	}
+	if !hasEvent(f.events(), ReasonPodIgnored) {
+		t.Errorf("expected a %s event", ReasonPodIgnored)
+	}
+}
@@ -0,0 +1,184 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// peerRoleLabel holds the current role of a peer pod, so clients can route
+// reads to learners and the disruption budget selects voting peers only.
+const peerRoleLabel = swarmLabel + "-role"
+
+// desiredRole returns the role of the peer at index, declared on spec peers
+// or laid out from the spec counts: witnesses first, then learners, voters
+// on the highest indexes so that scaling adds and removes voters.
+func desiredRole(sw *swarmv1alpha1.Swarm, index int) string {
+	for _, peer := range sw.Spec.Peers {
+		if peer.Index == index && peer.Role != "" {
+			return peer.Role
+		}
+	}
+
+	switch {
+	case index < sw.Spec.Witnesses:
+		return swarmv1alpha1.RoleWitness
+	case index < sw.Spec.Witnesses+sw.Spec.Learners:
+		return swarmv1alpha1.RoleLearner
+	default:
+		return swarmv1alpha1.RoleVoter
+	}
+}
+
+// votingRole reports whether role takes part in the quorum
+func votingRole(role string) bool {
+	return role == swarmv1alpha1.RoleVoter || role == swarmv1alpha1.RoleWitness
+}
+
+// votingSize returns how many peers of the swarm vote, learners excluded,
+// out of the Spec.Size peers it declares or Spec.Replicas when unset
+func votingSize(sw *swarmv1alpha1.Swarm) int {
+	size := sw.Spec.Size
+	if size == 0 {
+		size = sw.Spec.Replicas
+	}
+
+	voting := 0
+	for i := 0; i < size; i++ {
+		if votingRole(desiredRole(sw, i)) {
+			voting++
+		}
+	}
+	return voting
+}
+
+// validateRoles checks the role counts and declared roles fit the swarm
+func validateRoles(sw *swarmv1alpha1.Swarm) error {
+	if sw.Spec.Learners < 0 || sw.Spec.Witnesses < 0 {
+		return fmt.Errorf("learners %d and witnesses %d can't be negative", sw.Spec.Learners, sw.Spec.Witnesses)
+	}
+	if sw.Spec.Learners+sw.Spec.Witnesses >= sw.Spec.Replicas && sw.Spec.Replicas > 0 {
+		return fmt.Errorf("learners %d and witnesses %d leave no voter out of %d replicas", sw.Spec.Learners, sw.Spec.Witnesses, sw.Spec.Replicas)
+	}
+	for _, peer := range sw.Spec.Peers {
+		switch peer.Role {
+		case "", swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleLearner, swarmv1alpha1.RoleWitness:
+		default:
+			return fmt.Errorf("peer %s has unknown role %q", peer.ID, peer.Role)
+		}
+	}
+	return nil
+}
+
+// currentRole returns the role the peer holds now given its previous role.
+// Learners take their role at once. Peers joining the Pool are non voting,
+// a future voter or witness is a learner until promoted, unless the swarm is
+// bootstrapping without any voting peer.
+func currentRole(desired, previous string, bootstrap bool) string {
+	if !votingRole(desired) || votingRole(previous) || bootstrap {
+		return desired
+	}
+	return swarmv1alpha1.RoleLearner
+}
+
+// promoteLearner makes the learner at index vote through the Pool once its
+// pod is ready and it applied every entry the leader committed. It reports
+// whether the leader confirmed the promotion. Swarms without status endpoint
+// report no replication progress, their learners are promoted once ready.
+func (c *Controller) promoteLearner(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, index int, pod *corev1.Pod, pods []*corev1.Pod) (bool, error) {
+	if pod == nil || pod.DeletionTimestamp != nil || !podReady(pod) {
+		return false, nil
+	}
+
+	if endpoint := sw.Spec.Endpoints.Status; endpoint != nil {
+		caughtUp, err := c.caughtUp(ctx, key, sw, pod, pods, endpoint.HTTPEndpoint)
+		if err != nil || !caughtUp {
+			return false, err
+		}
+	}
+
+	id := peerID(sw, index)
+	if err := c.pool.Promote(ctx, sw, index, id); err != nil {
+		return false, fmt.Errorf("promoting peer %s: %v", id, err)
+	}
+	return true, nil
+}
+
+// caughtUp reports whether the peer on pod applied every entry the leader
+// had committed when asked, the leader is asked first. It is false while
+// the swarm has no known leader.
+func (c *Controller) caughtUp(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pod *corev1.Pod, pods []*corev1.Pod, endpoint swarmv1alpha1.HTTPEndpoint) (bool, error) {
+	var leader *corev1.Pod
+	for _, candidate := range pods {
+		if candidate.Name == sw.Status.Leader {
+			leader = candidate
+		}
+	}
+	if leader == nil || leader.Name == pod.Name {
+		klog.V(4).Infof("instance %s: no leader to compare peer %s progress with", key, pod.Name)
+		return false, nil
+	}
+
+	leaderState, err := c.queryPeer(ctx, leader, endpoint)
+	if err != nil {
+		return false, err
+	}
+	if leaderState.State != peerStateLeader {
+		klog.V(4).Infof("instance %s: peer %s is no longer the leader", key, leader.Name)
+		return false, nil
+	}
+	state, err := c.queryPeer(ctx, pod, endpoint)
+	if err != nil {
+		return false, err
+	}
+	if state.AppliedIndex < leaderState.CommitIndex {
+		klog.V(4).Infof("instance %s: peer %s applied %d of %d committed entries", key, pod.Name, state.AppliedIndex, leaderState.CommitIndex)
+		return false, nil
+	}
+	return true, nil
+}
+
+// labelPod patches the label of pod when it does not hold value already
+func (c *Controller) labelPod(ctx context.Context, pod *corev1.Pod, label, value string) error {
+	if pod == nil || pod.DeletionTimestamp != nil || pod.Labels[label] == value {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:%q}}}`, label, value)
+	_, err := c.kubeClientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// annotatePod patches the annotation of pod when it does not hold value
+// already, an empty value removes it
+func (c *Controller) annotatePod(ctx context.Context, pod *corev1.Pod, annotation, value string) error {
+	if pod == nil || pod.DeletionTimestamp != nil {
+		return nil
+	}
+	if current, ok := pod.Annotations[annotation]; current == value && (ok || value == "") {
+		return nil
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, annotation, value)
+	if value == "" {
+		patch = fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, annotation)
+	}
+	_, err := c.kubeClientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// votingPods filters the pods labeled with a voting role
+func votingPods(pods []*corev1.Pod) []*corev1.Pod {
+	var voting []*corev1.Pod
+	for _, pod := range pods {
+		if votingRole(pod.Labels[peerRoleLabel]) {
+			voting = append(voting, pod)
+		}
+	}
+	return voting
+}
@@ -0,0 +1,146 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// promotionFixture runs a three peer swarm led by foo-0 whose foo-2 peer is
+// a learner due to become a voter
+type promotionFixture struct {
+	*peerFixture
+	pool    *recordingPool
+	leader  *fakePeer
+	learner *fakePeer
+}
+
+func newPromotionFixture(t *testing.T) *promotionFixture {
+	sw := withStatusEndpoint(newTestSwarm("foo", 3))
+	sw.Status.Leader = "foo-0"
+	sw.Status.Term = 2
+	pool := &recordingPool{}
+	f := &promotionFixture{peerFixture: newPeerFixture(t, pool, sw), pool: pool}
+	f.leader, f.learner = f.peers[0], f.peers[2]
+	f.leader.set(func(s *peer.Status) { s.CommitIndex, s.AppliedIndex = 40, 40 })
+	f.learner.set(func(s *peer.Status) { s.CommitIndex, s.AppliedIndex = 12, 12 })
+
+	for i, role := range []string{swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleLearner} {
+		member := newSwarmPeer(sw, i)
+		member.Status.Role = role
+		f.addSwarmPeer(member)
+	}
+	return f
+}
+
+func (f *promotionFixture) reconcile() error {
+	return f.controller.reconcileSwarmPeers(context.Background(), swarmKey(f.sw), f.sw, f.pods)
+}
+
+// role returns the role recorded on the SwarmPeer and the pod label of the
+// peer at index
+func (f *promotionFixture) role(index int) (string, string) {
+	f.t.Helper()
+	name := peerPodName(f.sw, index)
+	member, err := f.swarmClient.K8slabV1alpha1().SwarmPeers(f.sw.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	pod, err := f.kubeClient.CoreV1().Pods(f.sw.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return member.Status.Role, pod.Labels[peerRoleLabel]
+}
+
+func TestLearnerBehindIsNotPromoted(t *testing.T) {
+	f := newPromotionFixture(t)
+
+	if err := f.reconcile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.pool.promoted) != 0 {
+		t.Errorf("expected no promotion while behind, got %v", f.pool.promoted)
+	}
+	if status, label := f.role(2); status != swarmv1alpha1.RoleLearner || label != swarmv1alpha1.RoleLearner {
+		t.Errorf("expected foo-2 to stay learner, got status %q label %q", status, label)
+	}
+	if f.sw.Status.Voters != 2 || f.sw.Status.Learners != 1 {
+		t.Errorf("expected 2 voters and 1 learner, got %d and %d", f.sw.Status.Voters, f.sw.Status.Learners)
+	}
+}
+
+func TestLearnerCaughtUpIsPromoted(t *testing.T) {
+	f := newPromotionFixture(t)
+	f.learner.set(func(s *peer.Status) { s.AppliedIndex = 40 })
+
+	if err := f.reconcile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.pool.promoted) != 1 || f.pool.promoted[0] != "foo-2" {
+		t.Fatalf("expected foo-2 promoted through the pool, got %v", f.pool.promoted)
+	}
+	if status, label := f.role(2); status != swarmv1alpha1.RoleVoter || label != swarmv1alpha1.RoleVoter {
+		t.Errorf("expected foo-2 voter, got status %q label %q", status, label)
+	}
+	if f.sw.Status.Voters != 3 {
+		t.Errorf("expected 3 voters, got %d", f.sw.Status.Voters)
+	}
+	if events := f.events(); !hasEvent(events, ReasonPeerPromoted) {
+		t.Errorf("expected a PeerPromoted event, got %v", events)
+	}
+}
+
+func TestLearnerStaysUntilLeaderConfirms(t *testing.T) {
+	f := newPromotionFixture(t)
+	f.learner.set(func(s *peer.Status) { s.AppliedIndex = 40 })
+	f.pool.err = errors.New("peer foo-2 still Nonvoter after promotion")
+
+	if err := f.reconcile(); err == nil {
+		t.Error("expected the failed promotion to be returned")
+	}
+	if status, label := f.role(2); status != swarmv1alpha1.RoleLearner || label != swarmv1alpha1.RoleLearner {
+		t.Errorf("expected foo-2 to stay learner, got status %q label %q", status, label)
+	}
+	if events := f.events(); hasEvent(events, ReasonPeerPromoted) {
+		t.Errorf("expected no PeerPromoted event, got %v", events)
+	}
+}
+
+func TestLearnerNotPromotedWithoutLeader(t *testing.T) {
+	f := newPromotionFixture(t)
+	f.learner.set(func(s *peer.Status) { s.AppliedIndex = 40 })
+	f.leader.set(func(s *peer.Status) { s.State = "Follower" })
+
+	if err := f.reconcile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.pool.promoted) != 0 {
+		t.Errorf("expected no promotion without a leader to compare with, got %v", f.pool.promoted)
+	}
+}
+
+func TestCurrentRole(t *testing.T) {
+	tests := []struct {
+		desired, previous string
+		bootstrap         bool
+		want              string
+	}{
+		{swarmv1alpha1.RoleVoter, "", true, swarmv1alpha1.RoleVoter},
+		{swarmv1alpha1.RoleVoter, "", false, swarmv1alpha1.RoleLearner},
+		{swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleLearner, false, swarmv1alpha1.RoleLearner},
+		{swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleVoter, false, swarmv1alpha1.RoleVoter},
+		{swarmv1alpha1.RoleWitness, "", false, swarmv1alpha1.RoleLearner},
+		{swarmv1alpha1.RoleWitness, swarmv1alpha1.RoleVoter, false, swarmv1alpha1.RoleWitness},
+		{swarmv1alpha1.RoleLearner, "", false, swarmv1alpha1.RoleLearner},
+	}
+	for _, tt := range tests {
+		if got := currentRole(tt.desired, tt.previous, tt.bootstrap); got != tt.want {
+			t.Errorf("currentRole(%q, %q, %v) = %q, want %q", tt.desired, tt.previous, tt.bootstrap, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package operator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// drainer tracks in-flight work items so that a shutdown can stop accepting
+// new work and wait for the running ones to complete.
+type drainer struct {
+	mutex    sync.Mutex
+	draining bool
+	inFlight map[string]int
+	wg       sync.WaitGroup
+}
+
+func newDrainer() *drainer {
+	return &drainer{
+		inFlight: make(map[string]int),
+	}
+}
+
+// begin registers key as in-flight, it returns false once draining started,
+// in which case the caller must not process the item.
+func (d *drainer) begin(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.draining {
+		return false
+	}
+
+	d.inFlight[key]++
+	d.wg.Add(1)
+	return true
+}
+
+// end marks key as completed
+func (d *drainer) end(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.inFlight[key]--; d.inFlight[key] <= 0 {
+		delete(d.inFlight, key)
+	}
+	d.wg.Done()
+}
+
+// drain stops accepting new work and waits up to gracePeriod for in-flight
+// items, it returns the keys still running when the grace period expired.
+func (d *drainer) drain(gracePeriod time.Duration) []string {
+	d.mutex.Lock()
+	d.draining = true
+	d.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(gracePeriod):
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	keys := make([]string, 0, len(d.inFlight))
+	for k := range d.inFlight {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
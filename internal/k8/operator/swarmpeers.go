@@ -0,0 +1,170 @@
+package operator
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// newSwarmPeer returns the SwarmPeer of the member at index, named after its
+// peer pod and owned by the Swarm.
+func newSwarmPeer(sw *swarmv1alpha1.Swarm, index int) *swarmv1alpha1.SwarmPeer {
+	return &swarmv1alpha1.SwarmPeer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      peerPodName(sw, index),
+			Namespace: sw.Namespace,
+			Labels: map[string]string{
+				swarmLabel:     sw.Name,
+				peerIndexLabel: strconv.Itoa(index),
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+		},
+		Spec: swarmv1alpha1.SwarmPeerSpec{
+			Swarm: sw.Name,
+			Index: int32(index),
+			ID:    peerID(sw, index),
+		},
+	}
+}
+
+// swarmPeerStatus observes the member state from its peer pod, pending while
+// the pod does not exist yet.
+func swarmPeerStatus(pod *corev1.Pod, role string) swarmv1alpha1.SwarmPeerStatus {
+	status := swarmv1alpha1.SwarmPeerStatus{
+		Phase: corev1.PodPending,
+		Role:  role,
+	}
+	if pod == nil {
+		return status
+	}
+
+	status.Phase = pod.Status.Phase
+	status.Address = pod.Status.PodIP
+	status.Healthy = pod.DeletionTimestamp == nil && unhealthySince(pod).IsZero()
+	return status
+}
+
+// reconcileSwarmPeers keeps one SwarmPeer per peer index below Spec.Replicas,
+// following member ID changes and updating each member status on its own.
+// Member roles are kept on SwarmPeer status, mirrored on peer pod labels and
+// counted on the swarm status. SwarmPeers above the desired replicas are
+// deleted.
+func (c *Controller) reconcileSwarmPeers(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) error {
+	existing, err := c.swarmPeerLister.SwarmPeers(sw.Namespace).List(labels.SelectorFromSet(labels.Set{swarmLabel: sw.Name}))
+	if err != nil {
+		return err
+	}
+	owned := make(map[string]*swarmv1alpha1.SwarmPeer, len(existing))
+	// without any voting member the swarm is bootstrapping, voters can't
+	// catch up with anyone and join as voters straight away
+	bootstrap := true
+	for _, peer := range existing {
+		if metav1.IsControlledBy(peer, sw) {
+			owned[peer.Name] = peer
+			if votingRole(peer.Status.Role) {
+				bootstrap = false
+			}
+		}
+	}
+	byName := make(map[string]*corev1.Pod, len(pods))
+	for _, pod := range pods {
+		byName[pod.Name] = pod
+	}
+
+	client := c.swarmClientset.K8slabV1alpha1().SwarmPeers(sw.Namespace)
+	var errs []error
+	var voters, learners, witnesses int32
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		desired := newSwarmPeer(sw, i)
+		pod := byName[desired.Name]
+
+		peer, ok := owned[desired.Name]
+		delete(owned, desired.Name)
+
+		// a replaced member, with a new ID, catches up again
+		var previous string
+		if ok && peer.Spec.ID == desired.Spec.ID {
+			previous = peer.Status.Role
+		}
+		role := currentRole(desiredRole(sw, i), previous, bootstrap)
+		if previous == swarmv1alpha1.RoleLearner && votingRole(desiredRole(sw, i)) {
+			// the role moves on once the leader confirms the promotion
+			promoted, err := c.promoteLearner(ctx, key, sw, i, pod, pods)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if promoted {
+				role = desiredRole(sw, i)
+				klog.Infof("instance %s: peer %s caught up, promoted to %s", key, desired.Name, role)
+				c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonPeerPromoted, "Peer %s caught up and was promoted to %s", desired.Spec.ID, role)
+			}
+		}
+		switch role {
+		case swarmv1alpha1.RoleVoter:
+			voters++
+		case swarmv1alpha1.RoleLearner:
+			learners++
+		case swarmv1alpha1.RoleWitness:
+			witnesses++
+		}
+		if err := c.labelPod(ctx, pod, peerRoleLabel, role); err != nil {
+			errs = append(errs, err)
+		}
+		status := swarmPeerStatus(pod, role)
+
+		if !ok {
+			peer, err = client.Create(ctx, desired, metav1.CreateOptions{})
+			if err != nil {
+				if !errors.IsAlreadyExists(err) {
+					errs = append(errs, err)
+				}
+				continue
+			}
+			klog.Infof("instance %s: swarm peer created: name=%s id=%s", key, peer.Name, peer.Spec.ID)
+		} else if peer.Spec != desired.Spec {
+			update := peer.DeepCopy()
+			update.Spec = desired.Spec
+			peer, err = client.Update(ctx, update, metav1.UpdateOptions{})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			klog.Infof("instance %s: swarm peer updated: name=%s id=%s", key, peer.Name, peer.Spec.ID)
+		}
+
+		if reflect.DeepEqual(peer.Status, status) {
+			continue
+		}
+		update := peer.DeepCopy()
+		update.Status = status
+		if _, err := client.UpdateStatus(ctx, update, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	sw.Status.Voters = voters
+	sw.Status.Learners = learners
+	sw.Status.Witnesses = witnesses
+
+	// owned peers left are above the desired replicas
+	for _, peer := range owned {
+		if peer.DeletionTimestamp != nil {
+			continue
+		}
+		if err := client.Delete(ctx, peer.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, err)
+			continue
+		}
+		klog.Infof("instance %s: swarm peer deleted: name=%s", key, peer.Name)
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
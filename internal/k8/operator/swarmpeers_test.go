@@ -0,0 +1,109 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// storedSwarmPeer reads the SwarmPeer name back from the API, nil when it is
+// gone
+func (f *fixture) storedSwarmPeer(sw *swarmv1alpha1.Swarm, name string) *swarmv1alpha1.SwarmPeer {
+	f.t.Helper()
+	peer, err := f.swarmClient.K8slabV1alpha1().SwarmPeers(sw.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return peer
+}
+
+func TestSwarmPeersCreatedPerIndex(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	pods := []*corev1.Pod{newTestPeer(sw, 0)}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	if err := f.controller.reconcileSwarmPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+
+	peer := f.storedSwarmPeer(sw, "foo-0")
+	if peer == nil || peer.Spec.Swarm != "foo" || peer.Spec.Index != 0 || peer.Spec.ID != "foo-0" {
+		t.Fatalf("expected foo-0 member of foo, got %v", peer)
+	}
+	if !metav1.IsControlledBy(peer, sw) || peer.Labels[peerIndexLabel] != "0" {
+		t.Errorf("expected foo-0 owned by foo and labeled, got %v %v", peer.OwnerReferences, peer.Labels)
+	}
+	if !peer.Status.Healthy || peer.Status.Address != "10.0.0.1" || peer.Status.Phase != corev1.PodRunning {
+		t.Errorf("expected foo-0 status observed from its pod, got %+v", peer.Status)
+	}
+
+	// the member exists before its pod does
+	pending := f.storedSwarmPeer(sw, "foo-1")
+	if pending == nil || pending.Spec.Index != 1 {
+		t.Fatalf("expected foo-1 created, got %v", pending)
+	}
+	if pending.Status.Healthy || pending.Status.Phase != corev1.PodPending {
+		t.Errorf("expected foo-1 pending, got %+v", pending.Status)
+	}
+}
+
+func TestSwarmPeerFollowsPeerIDChange(t *testing.T) {
+	sw := newTestSwarm("foo", 2)
+	pods := []*corev1.Pod{newTestPeer(sw, 0), newTestPeer(sw, 1)}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+	for i := 0; i < 2; i++ {
+		f.addSwarmPeer(newSwarmPeer(sw, i))
+	}
+
+	// healing replaced peer 1
+	sw.Status.Replacements = []swarmv1alpha1.PeerReplacement{{Index: 1, OldID: "foo-1", NewID: "foo-1-x9k2p"}}
+	if err := f.controller.reconcileSwarmPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+
+	if peer := f.storedSwarmPeer(sw, "foo-1"); peer.Spec.ID != "foo-1-x9k2p" || peer.Spec.Index != 1 {
+		t.Errorf("expected foo-1 updated to the replacement ID, got %+v", peer.Spec)
+	}
+	if peer := f.storedSwarmPeer(sw, "foo-0"); peer.Spec.ID != "foo-0" {
+		t.Errorf("expected foo-0 untouched, got %+v", peer.Spec)
+	}
+	for _, action := range f.swarmClient.Actions() {
+		if action.GetVerb() == "create" {
+			t.Errorf("expected existing members updated in place, got %v", action)
+		}
+	}
+}
+
+func TestSwarmPeersDeletedOnShrink(t *testing.T) {
+	sw := newTestSwarm("foo", 3)
+	pods := []*corev1.Pod{newTestPeer(sw, 0), newTestPeer(sw, 1)}
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+	for i := 0; i < 3; i++ {
+		f.addSwarmPeer(newSwarmPeer(sw, i))
+	}
+	// a member with the swarm label the swarm does not own
+	foreign := newSwarmPeer(sw, 3)
+	foreign.OwnerReferences = nil
+	f.addSwarmPeer(foreign)
+
+	sw.Spec.Replicas = 2
+	if err := f.controller.reconcileSwarmPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.storedSwarmPeer(sw, "foo-2") != nil {
+		t.Error("expected foo-2 deleted above the replicas")
+	}
+	for _, name := range []string{"foo-0", "foo-1", "foo-3"} {
+		if f.storedSwarmPeer(sw, name) == nil {
+			t.Errorf("expected %s kept", name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// defaultTransferTimeout is how long a leadership transfer may take when the
+// transfer endpoint sets no timeout
+const defaultTransferTimeout = time.Second * 30
+
+// transferRequest is the body POSTed to the leader transfer endpoint
+type transferRequest struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// handOverLeadership reports whether the peer running on pod can go away.
+// When pod runs the leader it asks it to transfer leadership to a healthy
+// voter first and holds the removal until discovery observes another
+// leader. A transfer timing out is reported and the removal goes on.
+// Swarms without transfer or status endpoints never hold removals.
+func (c *Controller) handOverLeadership(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pod *corev1.Pod) (bool, error) {
+	endpoints := sw.Spec.Endpoints
+	if endpoints.Transfer == nil || endpoints.Status == nil || sw.Status.Leader == "" || pod.Name != sw.Status.Leader {
+		return true, nil
+	}
+
+	timeout := defaultTransferTimeout
+	if endpoints.Transfer.TimeoutSeconds > 0 {
+		timeout = time.Duration(endpoints.Transfer.TimeoutSeconds) * time.Second
+	}
+
+	if t := sw.Status.LeaderTransfer; t != nil && t.From == pod.Name {
+		if time.Since(t.StartedAt.Time) < timeout {
+			klog.V(4).Infof("instance %s: waiting for leadership to move from %s to %s", key, t.From, t.To)
+			return false, nil
+		}
+		klog.Warningf("instance %s: leadership transfer from %s to %s timed out after %s", key, t.From, t.To, timeout)
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonLeaderTransferFailed, "Leadership transfer from %s to %s timed out after %s, removing the leader anyway", t.From, t.To, timeout)
+		sw.Status.LeaderTransfer = nil
+		return true, nil
+	}
+
+	target, err := c.transferTarget(sw, pod)
+	if err != nil {
+		return false, err
+	}
+	if target == nil {
+		klog.Warningf("instance %s: no healthy voter to take leadership over from %s", key, pod.Name)
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonLeaderTransferFailed, "No healthy voter to take leadership over from %s, removing the leader anyway", pod.Name)
+		return true, nil
+	}
+
+	targetID := target.Annotations[peerIDAnnotation]
+	if err := c.requestTransfer(ctx, pod, endpoints.Transfer.HTTPEndpoint, transferRequest{ID: targetID, Address: target.Status.PodIP}); err != nil {
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonLeaderTransferFailed, "Error asking %s to transfer leadership to %s: %v", pod.Name, target.Name, err)
+		return false, err
+	}
+
+	klog.Infof("instance %s: leadership transfer from %s to %s started", key, pod.Name, target.Name)
+	c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonLeaderTransfer, "Asked %s to transfer leadership to %s before removing it", pod.Name, target.Name)
+	sw.Status.LeaderTransfer = &swarmv1alpha1.LeaderTransfer{
+		From:      pod.Name,
+		To:        target.Name,
+		TargetID:  targetID,
+		StartedAt: metav1.Now(),
+	}
+	return false, nil
+}
+
+// transferTarget picks the healthy voter taking leadership over from the
+// leader pod, preferring peers already running the update revision and then
+// the lowest index. It returns nil when there is none.
+func (c *Controller) transferTarget(sw *swarmv1alpha1.Swarm, leader *corev1.Pod) (*corev1.Pod, error) {
+	pods, err := c.podLister.Pods(sw.Namespace).List(labels.SelectorFromSet(labels.Set{swarmLabel: sw.Name}))
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*corev1.Pod
+	for _, pod := range votingPods(peerPods(sw, pods)) {
+		if pod.Name == leader.Name || pod.Labels[peerRoleLabel] != swarmv1alpha1.RoleVoter {
+			continue
+		}
+		if !metav1.IsControlledBy(pod, sw) || pod.DeletionTimestamp != nil || pod.Status.PodIP == "" || !podReady(pod) {
+			continue
+		}
+		candidates = append(candidates, pod)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		iUpdated := candidates[i].Labels[peerRevisionLabel] == sw.Status.UpdateRevision
+		jUpdated := candidates[j].Labels[peerRevisionLabel] == sw.Status.UpdateRevision
+		if iUpdated != jUpdated {
+			return iUpdated
+		}
+		iIdx, _ := strconv.Atoi(candidates[i].Labels[peerIndexLabel])
+		jIdx, _ := strconv.Atoi(candidates[j].Labels[peerIndexLabel])
+		return iIdx < jIdx
+	})
+
+	return candidates[0], nil
+}
+
+// requestTransfer POSTs the transfer request to the leader admin endpoint
+func (c *Controller) requestTransfer(ctx context.Context, leader *corev1.Pod, endpoint swarmv1alpha1.HTTPEndpoint, transfer transferRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, peerRequestTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(transfer)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peerURL(leader, endpoint), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.peerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("peer %s transfer endpoint answered %s", leader.Name, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package operator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcosQuesada/swarm/internal/peer"
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// transferFixture runs a swarm led by foo-0 whose peers hold roles, each
+// peer serving its admin API
+type transferFixture struct {
+	*peerFixture
+}
+
+func newTransferFixture(t *testing.T, roles ...string) *transferFixture {
+	sw := withTransferEndpoint(newTestSwarm("foo", len(roles)), 30)
+	sw.Status.Leader = "foo-0"
+	sw.Status.LeaderID = "foo-0"
+	sw.Status.Term = 2
+	return &transferFixture{newPeerFixture(t, nil, sw, roles...)}
+}
+
+func (f *transferFixture) handOver() bool {
+	f.t.Helper()
+	done, err := f.controller.handOverLeadership(context.Background(), swarmKey(f.sw), f.sw, f.pods[0])
+	if err != nil {
+		f.t.Fatalf("unexpected error: %v", err)
+	}
+	return done
+}
+
+func TestLeadershipTransfer(t *testing.T) {
+	f := newTransferFixture(t, swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleVoter)
+
+	if f.handOver() {
+		t.Fatal("expected the leader removal held during the transfer")
+	}
+	transfers := f.peers[0].requestedTransfers()
+	if len(transfers) != 1 || transfers[0].ID != "foo-1" || transfers[0].Address != "10.0.0.2" {
+		t.Fatalf("expected a transfer to foo-1 on 10.0.0.2, got %v", transfers)
+	}
+	if tr := f.sw.Status.LeaderTransfer; tr == nil || tr.From != "foo-0" || tr.To != "foo-1" || tr.TargetID != "foo-1" {
+		t.Fatalf("expected the transfer recorded on status, got %+v", tr)
+	}
+
+	// the removal is held without asking again until the leader moves
+	if f.handOver() {
+		t.Error("expected the leader removal held while waiting")
+	}
+	if transfers := f.peers[0].requestedTransfers(); len(transfers) != 1 {
+		t.Errorf("expected a single transfer request, got %v", transfers)
+	}
+
+	f.peers[0].set(func(s *peer.Status) { s.State = "Follower"; s.Term = 3 })
+	f.peers[1].set(func(s *peer.Status) { s.State = peerStateLeader; s.Term = 3 })
+	if _, err := f.controller.reconcileLeader(context.Background(), swarmKey(f.sw), f.sw, f.pods); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.sw.Status.LeaderTransfer != nil || f.sw.Status.Leader != "foo-1" {
+		t.Errorf("expected the transfer done with foo-1 leading, got %+v led by %s", f.sw.Status.LeaderTransfer, f.sw.Status.Leader)
+	}
+	if !f.handOver() {
+		t.Error("expected the former leader removable")
+	}
+	if events := f.events(); !hasEvent(events, ReasonLeaderTransfer) || hasEvent(events, ReasonLeaderTransferFailed) {
+		t.Errorf("expected LeaderTransfer events only, got %v", events)
+	}
+}
+
+func TestLeadershipTransferTimeout(t *testing.T) {
+	f := newTransferFixture(t, swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleVoter)
+	f.sw.Status.LeaderTransfer = &swarmv1alpha1.LeaderTransfer{
+		From:      "foo-0",
+		To:        "foo-1",
+		TargetID:  "foo-1",
+		StartedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+	}
+
+	if !f.handOver() {
+		t.Fatal("expected the leader removed once the transfer timed out")
+	}
+	if f.sw.Status.LeaderTransfer != nil {
+		t.Errorf("expected the timed out transfer cleared, got %+v", f.sw.Status.LeaderTransfer)
+	}
+	if transfers := f.peers[0].requestedTransfers(); len(transfers) != 0 {
+		t.Errorf("expected no transfer asked again, got %v", transfers)
+	}
+	if events := f.events(); !hasEvent(events, ReasonLeaderTransferFailed) {
+		t.Errorf("expected a LeaderTransferFailed event, got %v", events)
+	}
+}
+
+func TestLeadershipTransferSkipsNonVoters(t *testing.T) {
+	f := newTransferFixture(t, swarmv1alpha1.RoleVoter, swarmv1alpha1.RoleLearner, swarmv1alpha1.RoleWitness)
+
+	if !f.handOver() {
+		t.Fatal("expected the leader removed without a voter to take over")
+	}
+	if transfers := f.peers[0].requestedTransfers(); len(transfers) != 0 {
+		t.Errorf("expected no transfer to a learner or witness, got %v", transfers)
+	}
+	if f.sw.Status.LeaderTransfer != nil {
+		t.Errorf("expected no transfer recorded, got %+v", f.sw.Status.LeaderTransfer)
+	}
+	if events := f.events(); !hasEvent(events, ReasonLeaderTransferFailed) {
+		t.Errorf("expected a LeaderTransferFailed event, got %v", events)
+	}
+}
@@ -0,0 +1,285 @@
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// peerRevisionLabel holds the revision a peer pod was built from, cached
+	// pods are stripped so revisions are compared by label.
+	peerRevisionLabel = swarmLabel + "-revision"
+	// peerRegisteredAnnotation holds the revision a peer was registered on
+	// the Pool with once replaced by a rolling update.
+	peerRegisteredAnnotation = swarmLabel + "-registered"
+)
+
+// podRevision is the part of the spec peer pods are built from, any change
+// on it rolls the peers. The selector and spec peers are peer identity and
+// do not roll them.
+type podRevision struct {
+	Template             *corev1.PodTemplateSpec        `json:"template,omitempty"`
+	Placement            *swarmv1alpha1.Placement       `json:"placement,omitempty"`
+	VolumeClaimTemplates []corev1.PersistentVolumeClaim `json:"volumeClaimTemplates,omitempty"`
+}
+
+func newPodRevision(sw *swarmv1alpha1.Swarm) podRevision {
+	return podRevision{
+		Template:             sw.Spec.Template,
+		Placement:            sw.Spec.Placement,
+		VolumeClaimTemplates: sw.Spec.VolumeClaimTemplates,
+	}
+}
+
+// apply returns a copy of sw building peer pods from the revision
+func (r podRevision) apply(sw *swarmv1alpha1.Swarm) *swarmv1alpha1.Swarm {
+	applied := sw.DeepCopy()
+	applied.Spec.Template = r.Template
+	applied.Spec.Placement = r.Placement
+	applied.Spec.VolumeClaimTemplates = r.VolumeClaimTemplates
+	return applied
+}
+
+// peerRevision hashes the parts of the spec peer pods are built from
+func peerRevision(sw *swarmv1alpha1.Swarm) (string, error) {
+	raw, err := json.Marshal(newPodRevision(sw))
+	if err != nil {
+		return "", err
+	}
+
+	hasher := fnv.New32a()
+	hasher.Write(raw)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32())), nil
+}
+
+// updateRevisionStatus records the update revision and how many peers run it,
+// the current revision moves forward once every peer is updated.
+func updateRevisionStatus(sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) error {
+	revision, err := peerRevision(sw)
+	if err != nil {
+		return err
+	}
+	sw.Status.UpdateRevision = revision
+
+	var updated int32
+	for _, pod := range pods {
+		if pod.Labels[peerRevisionLabel] == revision {
+			updated++
+		}
+	}
+	sw.Status.UpdatedReplicas = updated
+
+	if sw.Status.CurrentRevision == "" {
+		sw.Status.CurrentRevision = revision
+		return nil
+	}
+	if len(pods) != sw.Spec.Replicas {
+		return nil
+	}
+	for _, pod := range pods {
+		if pod.Labels[peerRevisionLabel] != revision || !peerRegistered(sw, pod) {
+			return nil
+		}
+	}
+	sw.Status.CurrentRevision = revision
+	return nil
+}
+
+// revisionName returns the ControllerRevision holding revision of the swarm
+func revisionName(sw *swarmv1alpha1.Swarm, revision string) string {
+	return sw.Name + "-" + revision
+}
+
+// saveRevision keeps the update revision on a ControllerRevision owned by
+// the swarm, peers below the partition are recreated from it once it is the
+// current revision.
+func (c *Controller) saveRevision(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) error {
+	raw, err := json.Marshal(newPodRevision(sw))
+	if err != nil {
+		return err
+	}
+
+	revision := &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            revisionName(sw, sw.Status.UpdateRevision),
+			Namespace:       sw.Namespace,
+			Labels:          map[string]string{swarmLabel: sw.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))},
+		},
+		Data: runtime.RawExtension{Raw: raw},
+	}
+	_, err = c.kubeClientset.AppsV1().ControllerRevisions(sw.Namespace).Create(ctx, revision, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	klog.Infof("instance %s: revision saved: name=%s", key, revision.Name)
+	return nil
+}
+
+// pruneRevisions deletes the swarm ControllerRevisions other than the
+// current and update ones
+func (c *Controller) pruneRevisions(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) error {
+	client := c.kubeClientset.AppsV1().ControllerRevisions(sw.Namespace)
+	list, err := client.List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{swarmLabel: sw.Name}).String()})
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{
+		revisionName(sw, sw.Status.CurrentRevision): true,
+		revisionName(sw, sw.Status.UpdateRevision):  true,
+	}
+	var errs []error
+	for _, revision := range list.Items {
+		if keep[revision.Name] || !metav1.IsControlledBy(&revision, sw) {
+			continue
+		}
+		if err := client.Delete(ctx, revision.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, err)
+			continue
+		}
+		klog.Infof("instance %s: revision deleted: name=%s", key, revision.Name)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// newPeerPod returns the pod of the peer at index. Peers below the partition
+// of a rolling update in progress are built from the current revision, or
+// from the update revision when the current one was never saved.
+func (c *Controller) newPeerPod(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, index int) (*corev1.Pod, error) {
+	current := sw.Status.CurrentRevision
+	if sw.Spec.UpdateStrategy.Type == swarmv1alpha1.OnDeleteStrategy || index >= sw.Spec.UpdateStrategy.Partition || current == "" || current == sw.Status.UpdateRevision {
+		return newPodForCR(sw, index)
+	}
+
+	found, err := c.kubeClientset.AppsV1().ControllerRevisions(sw.Namespace).Get(ctx, revisionName(sw, current), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		klog.Warningf("instance %s: revision %s not found, peer %d built from the update revision", key, current, index)
+		return newPodForCR(sw, index)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var revision podRevision
+	if err := json.Unmarshal(found.Data.Raw, &revision); err != nil {
+		return nil, fmt.Errorf("revision %s decode: %v", found.Name, err)
+	}
+	return newPodForCR(revision.apply(sw), index)
+}
+
+// peerRegistered reports whether pod needs no Pool registration, peers of the
+// current revision were registered on creation, replaced ones once ready.
+func peerRegistered(sw *swarmv1alpha1.Swarm, pod *corev1.Pod) bool {
+	revision := pod.Labels[peerRevisionLabel]
+	return revision == sw.Status.CurrentRevision || pod.Annotations[peerRegisteredAnnotation] == revision
+}
+
+// rollPeers replaces one outdated peer at a time with the update revision,
+// peers at or above the partition are replaced in reverse index order and the
+// current leader last. A replaced peer must be ready and registered on the
+// Pool before the next one goes down. Peers below the partition are recreated
+// on the current revision.
+func (c *Controller) rollPeers(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pods []*corev1.Pod) error {
+	if sw.Spec.UpdateStrategy.Type == swarmv1alpha1.OnDeleteStrategy {
+		return nil
+	}
+
+	revision := sw.Status.UpdateRevision
+	if len(pods) < sw.Spec.Replicas {
+		// missing peers are being created, let them settle first
+		return nil
+	}
+	for _, pod := range pods {
+		if !podReady(pod) {
+			klog.V(4).Infof("instance %s: rolling update waits for peer %s readiness", key, pod.Name)
+			return nil
+		}
+	}
+	for _, pod := range pods {
+		if !peerRegistered(sw, pod) {
+			return c.registerPeer(ctx, key, sw, pod)
+		}
+	}
+
+	var outdated []*corev1.Pod
+	indexes := make(map[string]int, len(pods))
+	for _, pod := range pods {
+		idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+		if err != nil || idx < sw.Spec.UpdateStrategy.Partition {
+			continue
+		}
+		if pod.Labels[peerRevisionLabel] != revision {
+			outdated = append(outdated, pod)
+			indexes[pod.Name] = idx
+		}
+	}
+	if len(outdated) == 0 {
+		return nil
+	}
+
+	// indexes are compared as numbers, foo-10 goes before foo-9
+	sort.Slice(outdated, func(i, j int) bool {
+		if leader := sw.Status.Leader; outdated[i].Name == leader || outdated[j].Name == leader {
+			return outdated[j].Name == leader
+		}
+		return indexes[outdated[i].Name] > indexes[outdated[j].Name]
+	})
+
+	pod := outdated[0]
+	if ok, err := c.handOverLeadership(ctx, key, sw, pod); !ok || err != nil {
+		return err
+	}
+	klog.Infof("instance %s: rolling peer %s from revision %s to %s", key, pod.Name, pod.Labels[peerRevisionLabel], revision)
+	c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonPeerUpdating, "Replacing peer pod %s with revision %s", pod.Name, revision)
+	c.expectations.expectDeletions(key, 1)
+	return c.deletePeer(ctx, key, sw, pod)
+}
+
+// registerPeer adds the replaced peer back on the Pool and marks it as
+// registered for its revision.
+func (c *Controller) registerPeer(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, pod *corev1.Pod) error {
+	idx, err := strconv.Atoi(pod.Labels[peerIndexLabel])
+	if err != nil {
+		return fmt.Errorf("peer %s has invalid index label: %v", pod.Name, err)
+	}
+
+	id := pod.Annotations[peerIDAnnotation]
+	if id == "" {
+		id = peerID(sw, idx)
+	}
+
+	if err := c.pool.Add(ctx, sw, idx, id, net.ParseIP(pod.Status.PodIP)); err != nil {
+		c.recorder.Eventf(sw, corev1.EventTypeWarning, ReasonPeerFailed, "Error registering peer pod %s: %v", pod.Name, err)
+		return err
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, peerRegisteredAnnotation, pod.Labels[peerRevisionLabel])
+	if _, err := c.kubeClientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return err
+	}
+
+	klog.Infof("instance %s: peer %s registered with revision %s", key, pod.Name, pod.Labels[peerRevisionLabel])
+	c.recorder.Eventf(sw, corev1.EventTypeNormal, ReasonPeerUpdated, "Peer pod %s updated to revision %s", pod.Name, pod.Labels[peerRevisionLabel])
+	return nil
+}
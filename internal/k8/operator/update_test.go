@@ -0,0 +1,187 @@
+package operator
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	core "k8s.io/client-go/testing"
+)
+
+func withImage(sw *swarmv1alpha1.Swarm, image string) *swarmv1alpha1.Swarm {
+	sw.Spec.Template = &corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "peer", Image: image}}},
+	}
+	return sw
+}
+
+func mustRevision(t *testing.T, sw *swarmv1alpha1.Swarm) string {
+	t.Helper()
+	revision, err := peerRevision(sw)
+	if err != nil {
+		t.Fatalf("unexpected revision error: %v", err)
+	}
+	return revision
+}
+
+func TestPeerBelowPartitionRecreatedOnCurrentRevision(t *testing.T) {
+	previous := withImage(newTestSwarm("foo", 2), "peer:1")
+	current := mustRevision(t, previous)
+	previous.Status.UpdateRevision = current
+	pods := []*corev1.Pod{newTestPeer(previous, 0), newTestPeer(previous, 1)}
+
+	sw := withImage(previous.DeepCopy(), "peer:2")
+	sw.Spec.UpdateStrategy.Partition = 2
+	sw.Status.CurrentRevision = current
+	sw.Status.UpdateRevision = current
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	if err := f.controller.saveRevision(context.Background(), swarmKey(previous), previous); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	f.removePod(pods[1])
+	f.sync(swarmKey(sw))
+
+	created, err := f.kubeClient.CoreV1().Pods(sw.Namespace).Get(context.Background(), "foo-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected foo-1 recreated: %v", err)
+	}
+	if revision := created.Labels[peerRevisionLabel]; revision != current {
+		t.Errorf("expected foo-1 on the current revision %s, got %s", current, revision)
+	}
+	if image := created.Spec.Containers[0].Image; image != "peer:1" {
+		t.Errorf("expected foo-1 built from the current template, got image %s", image)
+	}
+
+	update := mustRevision(t, sw)
+	if _, err := f.kubeClient.AppsV1().ControllerRevisions(sw.Namespace).Get(context.Background(), revisionName(sw, update), metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the update revision saved: %v", err)
+	}
+}
+
+func TestPeerAbovePartitionRecreatedOnUpdateRevision(t *testing.T) {
+	previous := withImage(newTestSwarm("foo", 2), "peer:1")
+	sw := withImage(previous.DeepCopy(), "peer:2")
+	sw.Spec.UpdateStrategy.Partition = 1
+	sw.Status.CurrentRevision = mustRevision(t, previous)
+	sw.Status.UpdateRevision = mustRevision(t, sw)
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+
+	pod, err := f.controller.newPeerPod(context.Background(), swarmKey(sw), sw, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Labels[peerRevisionLabel] != sw.Status.UpdateRevision || pod.Spec.Containers[0].Image != "peer:2" {
+		t.Errorf("expected the update revision, got %s image %s", pod.Labels[peerRevisionLabel], pod.Spec.Containers[0].Image)
+	}
+
+	// the current revision was never saved
+	pod, err = f.controller.newPeerPod(context.Background(), swarmKey(sw), sw, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pod.Labels[peerRevisionLabel] != sw.Status.UpdateRevision {
+		t.Errorf("expected the update revision fallback, got %s", pod.Labels[peerRevisionLabel])
+	}
+}
+
+func TestPruneRevisions(t *testing.T) {
+	ctx := context.Background()
+	sw := newTestSwarm("foo", 1)
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+	for _, image := range []string{"peer:1", "peer:2", "peer:3"} {
+		revisioned := withImage(sw.DeepCopy(), image)
+		revisioned.Status.UpdateRevision = mustRevision(t, revisioned)
+		if err := f.controller.saveRevision(ctx, swarmKey(sw), revisioned); err != nil {
+			t.Fatalf("unexpected save error: %v", err)
+		}
+	}
+
+	sw = withImage(sw, "peer:3")
+	sw.Status.CurrentRevision = mustRevision(t, sw)
+	sw.Status.UpdateRevision = sw.Status.CurrentRevision
+	if err := f.controller.pruneRevisions(ctx, swarmKey(sw), sw); err != nil {
+		t.Fatalf("unexpected prune error: %v", err)
+	}
+
+	list, err := f.kubeClient.AppsV1().ControllerRevisions(sw.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != revisionName(sw, sw.Status.CurrentRevision) {
+		t.Errorf("expected the current revision kept only, got %v", list.Items)
+	}
+}
+
+func TestPeerRevisionCoversVolumeClaimTemplates(t *testing.T) {
+	sw := withImage(newTestSwarm("foo", 1), "peer:1")
+	before := mustRevision(t, sw)
+
+	sw.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{
+		ObjectMeta: metav1.ObjectMeta{Name: "data"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}}
+	if after := mustRevision(t, sw); after == before {
+		t.Error("expected volume claim templates to change the revision")
+	}
+
+	// identity fields do not roll peers
+	withClaims := mustRevision(t, sw)
+	sw.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}}
+	if after := mustRevision(t, sw); after != withClaims {
+		t.Error("expected the selector not to change the revision")
+	}
+}
+
+func TestRollPeersInReverseIndexOrderLeaderLast(t *testing.T) {
+	previous := withImage(newTestSwarm("foo", 11), "peer:1")
+	var pods []*corev1.Pod
+	for i := 0; i < 11; i++ {
+		pods = append(pods, newTestPeer(previous, i))
+	}
+	sw := withImage(previous.DeepCopy(), "peer:2")
+	sw.Status.CurrentRevision = mustRevision(t, previous)
+	sw.Status.UpdateRevision = mustRevision(t, sw)
+	sw.Status.Leader = "foo-5"
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+
+	// every round deletes one peer, it comes back updated and registered
+	var rolled []string
+	for round := 0; round < 11; round++ {
+		f.kubeClient.ClearActions()
+		if err := f.controller.rollPeers(context.Background(), swarmKey(sw), sw, pods); err != nil {
+			t.Fatal(err)
+		}
+		var deleted string
+		for _, action := range f.kubeClient.Actions() {
+			if action.GetVerb() == "delete" && action.GetResource().Resource == "pods" {
+				deleted = action.(core.DeleteAction).GetName()
+			}
+		}
+		if deleted == "" {
+			t.Fatalf("expected a peer rolled on round %d", round)
+		}
+		rolled = append(rolled, deleted)
+		f.controller.expectations.deletionObserved(swarmKey(sw))
+
+		for i, pod := range pods {
+			if pod.Name == deleted {
+				pods[i] = newTestPeer(sw, i)
+				pods[i].Annotations[peerRegisteredAnnotation] = sw.Status.UpdateRevision
+			}
+		}
+	}
+
+	want := []string{"foo-10", "foo-9", "foo-8", "foo-7", "foo-6", "foo-4", "foo-3", "foo-2", "foo-1", "foo-0", "foo-5"}
+	if strings.Join(rolled, ",") != strings.Join(want, ",") {
+		t.Errorf("expected peers rolled as %v, got %v", want, rolled)
+	}
+}
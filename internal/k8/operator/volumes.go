@@ -0,0 +1,180 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// peerDataDir is where peer claims are mounted, one directory per template
+const peerDataDir = "/var/lib/swarm"
+
+// peerClaimName returns the claim of the peer at index for template, stable
+// across peer recreations.
+func peerClaimName(sw *swarmv1alpha1.Swarm, template corev1.PersistentVolumeClaim, index int) string {
+	return fmt.Sprintf("%s-%s-%d", template.Name, sw.Name, index)
+}
+
+// newClaimForPeer returns the claim of the peer at index built from template,
+// it is owned by the Swarm only when claims are deleted with it.
+func newClaimForPeer(sw *swarmv1alpha1.Swarm, template corev1.PersistentVolumeClaim, index int) *corev1.PersistentVolumeClaim {
+	claimLabels := map[string]string{}
+	for k, v := range template.Labels {
+		claimLabels[k] = v
+	}
+	claimLabels[swarmLabel] = sw.Name
+	claimLabels[peerIndexLabel] = strconv.Itoa(index)
+
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        peerClaimName(sw, template, index),
+			Namespace:   sw.Namespace,
+			Labels:      claimLabels,
+			Annotations: template.Annotations,
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+	if sw.Spec.VolumeClaimRetention.WhenDeleted == swarmv1alpha1.DeleteVolumeClaimRetentionPolicy {
+		claim.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(sw, swarmv1alpha1.SchemeGroupVersion.WithKind("Swarm"))}
+	}
+
+	return claim
+}
+
+// addPeerVolumes mounts the peer claims on pod
+func addPeerVolumes(sw *swarmv1alpha1.Swarm, pod *corev1.Pod, index int) {
+	for _, template := range sw.Spec.VolumeClaimTemplates {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: template.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: peerClaimName(sw, template, index),
+				},
+			},
+		})
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      template.Name,
+				MountPath: path.Join(peerDataDir, template.Name),
+			})
+		}
+	}
+}
+
+// reconcileVolumes creates the missing claims of every peer below replicas,
+// keeps claim ownership in line with the deletion retention policy and
+// removes the claims of scaled down peers when asked to. It returns the peer
+// claims binding state.
+func (c *Controller) reconcileVolumes(ctx context.Context, key string, sw *swarmv1alpha1.Swarm) ([]swarmv1alpha1.PeerVolumeStatus, error) {
+	if len(sw.Spec.VolumeClaimTemplates) == 0 {
+		return nil, nil
+	}
+
+	claims, err := c.pvcLister.PersistentVolumeClaims(sw.Namespace).List(labels.SelectorFromSet(labels.Set{swarmLabel: sw.Name}))
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*corev1.PersistentVolumeClaim, len(claims))
+	for _, claim := range claims {
+		existing[claim.Name] = claim
+	}
+
+	var status []swarmv1alpha1.PeerVolumeStatus
+	var errs []error
+	for i := 0; i < sw.Spec.Replicas; i++ {
+		for _, template := range sw.Spec.VolumeClaimTemplates {
+			claim := newClaimForPeer(sw, template, i)
+			found, ok := existing[claim.Name]
+			delete(existing, claim.Name)
+			if !ok {
+				if _, err := c.kubeClientset.CoreV1().PersistentVolumeClaims(sw.Namespace).Create(ctx, claim, metav1.CreateOptions{}); err != nil && !errors.IsAlreadyExists(err) {
+					errs = append(errs, err)
+					continue
+				}
+				klog.Infof("instance %s: claim created: name=%s", key, claim.Name)
+				status = append(status, swarmv1alpha1.PeerVolumeStatus{Index: i, Claim: claim.Name, Phase: corev1.ClaimPending})
+				continue
+			}
+
+			if err := c.syncClaimOwner(ctx, sw, found); err != nil {
+				errs = append(errs, err)
+			}
+			status = append(status, swarmv1alpha1.PeerVolumeStatus{Index: i, Claim: found.Name, Phase: found.Status.Phase})
+		}
+	}
+
+	// claims left belong to peers removed by a scale down
+	if sw.Spec.VolumeClaimRetention.WhenScaled == swarmv1alpha1.DeleteVolumeClaimRetentionPolicy {
+		for _, claim := range existing {
+			if err := c.deleteScaledClaim(ctx, key, sw, claim); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].Index != status[j].Index {
+			return status[i].Index < status[j].Index
+		}
+		return status[i].Claim < status[j].Claim
+	})
+
+	return status, utilerrors.NewAggregate(errs)
+}
+
+// syncClaimOwner adds or removes the Swarm owner reference of claim following
+// the deletion retention policy, which may change after claim creation.
+func (c *Controller) syncClaimOwner(ctx context.Context, sw *swarmv1alpha1.Swarm, claim *corev1.PersistentVolumeClaim) error {
+	owned := false
+	for _, ref := range claim.OwnerReferences {
+		if ref.UID == sw.UID {
+			owned = true
+		}
+	}
+
+	var patch string
+	switch wantOwned := sw.Spec.VolumeClaimRetention.WhenDeleted == swarmv1alpha1.DeleteVolumeClaimRetentionPolicy; {
+	case wantOwned && !owned:
+		patch = fmt.Sprintf(`{"metadata":{"ownerReferences":[{"apiVersion":%q,"kind":"Swarm","name":%q,"uid":%q,"controller":true,"blockOwnerDeletion":true}],"uid":%q}}`,
+			swarmv1alpha1.SchemeGroupVersion.String(), sw.Name, sw.UID, claim.UID)
+	case !wantOwned && owned:
+		patch = fmt.Sprintf(`{"metadata":{"ownerReferences":[{"$patch":"delete","uid":%q}],"uid":%q}}`, sw.UID, claim.UID)
+	default:
+		return nil
+	}
+
+	_, err := c.kubeClientset.CoreV1().PersistentVolumeClaims(claim.Namespace).Patch(ctx, claim.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+// deleteScaledClaim removes the claim of a scaled down peer once its pod is gone
+func (c *Controller) deleteScaledClaim(ctx context.Context, key string, sw *swarmv1alpha1.Swarm, claim *corev1.PersistentVolumeClaim) error {
+	idx, err := strconv.Atoi(claim.Labels[peerIndexLabel])
+	if err != nil || idx < sw.Spec.Replicas || claim.DeletionTimestamp != nil {
+		return nil
+	}
+
+	if _, err := c.podLister.Pods(sw.Namespace).Get(peerPodName(sw, idx)); err == nil {
+		// the peer is still terminating, next reconcile will remove its claim
+		return nil
+	}
+
+	err = c.kubeClientset.CoreV1().PersistentVolumeClaims(claim.Namespace).Delete(ctx, claim.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	klog.Infof("instance %s: claim deleted on scale down: name=%s", key, claim.Name)
+	return nil
+}
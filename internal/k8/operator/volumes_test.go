@@ -0,0 +1,141 @@
+package operator
+
+import (
+	"context"
+	"testing"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// withDataClaims adds a data claim template to sw with the retention policy
+func withDataClaims(sw *swarmv1alpha1.Swarm, whenDeleted, whenScaled swarmv1alpha1.VolumeClaimRetentionPolicyType) *swarmv1alpha1.Swarm {
+	sw.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}}
+	sw.Spec.VolumeClaimRetention = swarmv1alpha1.VolumeClaimRetentionPolicy{WhenDeleted: whenDeleted, WhenScaled: whenScaled}
+	return sw
+}
+
+// addClaim stores claim on the API and the cache
+func (f *fixture) addClaim(claim *corev1.PersistentVolumeClaim) {
+	if err := f.kubeClient.Tracker().Add(claim); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := f.kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(claim); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// storedClaim reads the claim name back from the API, nil when it is gone
+func (f *fixture) storedClaim(sw *swarmv1alpha1.Swarm, name string) *corev1.PersistentVolumeClaim {
+	f.t.Helper()
+	claim, err := f.kubeClient.CoreV1().PersistentVolumeClaims(sw.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return claim
+}
+
+func TestPeerClaimsNamedByIndex(t *testing.T) {
+	sw := withDataClaims(newTestSwarm("foo", 2), "", "")
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+
+	volumes, err := f.controller.reconcileVolumes(context.Background(), swarmKey(sw), sw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 || volumes[0].Claim != "data-foo-0" || volumes[1].Claim != "data-foo-1" {
+		t.Fatalf("expected data-foo-0 and data-foo-1 reported, got %v", volumes)
+	}
+	claim := f.storedClaim(sw, "data-foo-1")
+	if claim == nil || claim.Labels[peerIndexLabel] != "1" || claim.Labels[swarmLabel] != "foo" {
+		t.Fatalf("expected data-foo-1 labeled for peer 1, got %v", claim)
+	}
+	// claims are kept by default when the swarm is deleted
+	if len(claim.OwnerReferences) != 0 {
+		t.Errorf("expected retained claims not owned by the swarm, got %v", claim.OwnerReferences)
+	}
+
+	// a recreated peer mounts the same claim
+	pod, err := newPodForCR(sw, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mounted := false
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == "data" && volume.PersistentVolumeClaim != nil {
+			mounted = volume.PersistentVolumeClaim.ClaimName == "data-foo-1"
+		}
+	}
+	if !mounted {
+		t.Errorf("expected peer 1 mounting data-foo-1, got %v", pod.Spec.Volumes)
+	}
+}
+
+func TestPeerClaimOwnerFollowsRetention(t *testing.T) {
+	sw := withDataClaims(newTestSwarm("foo", 1), swarmv1alpha1.DeleteVolumeClaimRetentionPolicy, "")
+	f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, nil)
+
+	if _, err := f.controller.reconcileVolumes(context.Background(), swarmKey(sw), sw); err != nil {
+		t.Fatal(err)
+	}
+	claim := f.storedClaim(sw, "data-foo-0")
+	if !metav1.IsControlledBy(claim, sw) {
+		t.Fatalf("expected claims deleted with the swarm owned by it, got %v", claim.OwnerReferences)
+	}
+	if err := f.kubeInformers.Core().V1().PersistentVolumeClaims().Informer().GetIndexer().Add(claim); err != nil {
+		t.Fatal(err)
+	}
+
+	// switching to retain releases the existing claim
+	sw.Spec.VolumeClaimRetention.WhenDeleted = swarmv1alpha1.RetainVolumeClaimRetentionPolicy
+	if _, err := f.controller.reconcileVolumes(context.Background(), swarmKey(sw), sw); err != nil {
+		t.Fatal(err)
+	}
+	if claim := f.storedClaim(sw, "data-foo-0"); len(claim.OwnerReferences) != 0 {
+		t.Errorf("expected the retained claim released, got %v", claim.OwnerReferences)
+	}
+}
+
+func TestScaledDownPeerClaims(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		whenScaled swarmv1alpha1.VolumeClaimRetentionPolicyType
+		podLeft    bool
+		deleted    bool
+	}{
+		{name: "retained", whenScaled: swarmv1alpha1.RetainVolumeClaimRetentionPolicy},
+		{name: "deleted", whenScaled: swarmv1alpha1.DeleteVolumeClaimRetentionPolicy, deleted: true},
+		{name: "peer terminating", whenScaled: swarmv1alpha1.DeleteVolumeClaimRetentionPolicy, podLeft: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := withDataClaims(newTestSwarm("foo", 1), "", tt.whenScaled)
+			var pods []*corev1.Pod
+			if tt.podLeft {
+				pods = append(pods, newTestPeer(sw, 1))
+			}
+			f := newFixture(t, nil, []*swarmv1alpha1.Swarm{sw}, pods)
+			// the claims of a swarm scaled from two peers to one
+			f.addClaim(newClaimForPeer(sw, sw.Spec.VolumeClaimTemplates[0], 0))
+			f.addClaim(newClaimForPeer(sw, sw.Spec.VolumeClaimTemplates[0], 1))
+
+			volumes, err := f.controller.reconcileVolumes(context.Background(), swarmKey(sw), sw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(volumes) != 1 || volumes[0].Claim != "data-foo-0" {
+				t.Errorf("expected only data-foo-0 reported, got %v", volumes)
+			}
+			if deleted := f.storedClaim(sw, "data-foo-1") == nil; deleted != tt.deleted {
+				t.Errorf("expected data-foo-1 deleted %v, got %v", tt.deleted, deleted)
+			}
+			if f.storedClaim(sw, "data-foo-0") == nil {
+				t.Error("expected data-foo-0 kept")
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package peer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Admin API paths served by every peer
+const (
+	StatusPath   = "/status"
+	JoinPath     = "/join"
+	LeavePath    = "/leave"
+	TransferPath = "/transfer"
+	KVPath       = "/kv/"
+)
+
+// JoinRequest adds a member, as non voting learner unless Voter is set. Joining
+// an existing learner as voter promotes it.
+type JoinRequest struct {
+	Member
+	Voter bool `json:"voter"`
+}
+
+// LeaveRequest removes a member
+type LeaveRequest struct {
+	ID string `json:"id"`
+}
+
+// TransferRequest hands leadership over to a member, its address is looked
+// up on the swarm configuration.
+type TransferRequest struct {
+	ID      string `json:"id"`
+	Address string `json:"address,omitempty"`
+}
+
+// NewAPI returns the admin and key value HTTP API of node. Writes reaching a
+// follower are redirected to the leader with a temporary redirect.
+func NewAPI(node *Node) http.Handler {
+	a := &api{node: node}
+	mux := http.NewServeMux()
+	mux.HandleFunc(StatusPath, a.status)
+	mux.HandleFunc(JoinPath, a.join)
+	mux.HandleFunc(LeavePath, a.leave)
+	mux.HandleFunc(TransferPath, a.transfer)
+	mux.HandleFunc(KVPath, a.kv)
+	return mux
+}
+
+type api struct {
+	node *Node
+}
+
+func (a *api) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, err := a.node.Status()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
+
+func (a *api) join(w http.ResponseWriter, r *http.Request) {
+	var req JoinRequest
+	if !decodePost(w, r, &req) {
+		return
+	}
+	if req.ID == "" || req.Address == "" {
+		http.Error(w, "id and address are required", http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("join request id %s address %s voter %t", req.ID, req.Address, req.Voter)
+	a.reply(w, r, a.node.Join(req.Member, req.Voter))
+}
+
+func (a *api) leave(w http.ResponseWriter, r *http.Request) {
+	var req LeaveRequest
+	if !decodePost(w, r, &req) {
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("leave request id %s", req.ID)
+	a.reply(w, r, a.node.Leave(req.ID))
+}
+
+func (a *api) transfer(w http.ResponseWriter, r *http.Request) {
+	var req TransferRequest
+	if !decodePost(w, r, &req) {
+		return
+	}
+	if req.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	log.Infof("leadership transfer request to %s", req.ID)
+	a.reply(w, r, a.node.Transfer(req.ID))
+}
+
+func (a *api) kv(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, KVPath)
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, ok := a.node.Get(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(value))
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.reply(w, r, a.node.Set(key, string(value)))
+	case http.MethodDelete:
+		a.reply(w, r, a.node.Delete(key))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reply answers a write, redirecting it to the leader when this peer is not
+func (a *api) reply(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case err == ErrNotLeader:
+		leader := a.node.LeaderAdminAddress()
+		if leader == "" {
+			http.Error(w, "no leader elected", http.StatusServiceUnavailable)
+			return
+		}
+		u := url.URL{Scheme: "http", Host: leader, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, u.String(), http.StatusTemporaryRedirect)
+	default:
+		log.Errorf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func decodePost(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("encoding response: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package peer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Environment the controller sets on peer containers
+const (
+	// EnvPeerID is the peer ID the member joins the swarm with
+	EnvPeerID = "SWARM_PEER_ID"
+	// EnvPeerAddress is the host other peers reach the member on
+	EnvPeerAddress = "SWARM_PEER_ADDRESS"
+	// EnvPeers lists the initial voting members as comma separated id=host
+	EnvPeers = "SWARM_PEERS"
+)
+
+// ConfigFromEnv fills the peer identity and initial members of config from
+// the environment.
+func ConfigFromEnv(config *Config) error {
+	config.ID = os.Getenv(EnvPeerID)
+	config.Address = os.Getenv(EnvPeerAddress)
+
+	peers, err := ParseMembers(os.Getenv(EnvPeers))
+	if err != nil {
+		return fmt.Errorf("%s: %v", EnvPeers, err)
+	}
+	config.Peers = peers
+	return nil
+}
+
+// ParseMembers parses a comma separated id=host member list
+func ParseMembers(list string) ([]Member, error) {
+	var members []Member
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid member %q, expected id=host", entry)
+		}
+		members = append(members, Member{ID: parts[0], Address: parts[1]})
+	}
+	return members, nil
+}
+
+// FormatMembers formats members as parsed by ParseMembers
+func FormatMembers(members []Member) string {
+	entries := make([]string, 0, len(members))
+	for _, m := range members {
+		entries = append(entries, m.ID+"="+m.Address)
+	}
+	return strings.Join(entries, ",")
+}
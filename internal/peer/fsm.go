@@ -0,0 +1,102 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+const (
+	opSet    = "set"
+	opDelete = "delete"
+)
+
+// command is a replicated key value mutation
+type command struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// store is the replicated key value state machine
+type store struct {
+	mutex sync.RWMutex
+	data  map[string]string
+}
+
+func newStore() *store {
+	return &store{data: make(map[string]string)}
+}
+
+func (s *store) get(key string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Apply applies a committed log entry
+func (s *store) Apply(l *raft.Log) interface{} {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("invalid command at index %d: %v", l.Index, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch cmd.Op {
+	case opSet:
+		s.data[cmd.Key] = cmd.Value
+	case opDelete:
+		delete(s.data, cmd.Key)
+	default:
+		return fmt.Errorf("unknown command op %q at index %d", cmd.Op, l.Index)
+	}
+	return nil
+}
+
+// Snapshot copies the current state, written out later by Persist
+func (s *store) Snapshot() (raft.FSMSnapshot, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return &snapshot{data: data}, nil
+}
+
+// Restore replaces the state with a snapshot
+func (s *store) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	data := make(map[string]string)
+	if err := json.NewDecoder(rc).Decode(&data); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data = data
+	return nil
+}
+
+type snapshot struct {
+	data map[string]string
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s.data); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *snapshot) Release() {}
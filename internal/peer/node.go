@@ -0,0 +1,306 @@
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+const (
+	// applyTimeout bounds replication of a single command or membership change
+	applyTimeout = time.Second * 10
+	// retainSnapshots is how many snapshots are kept on disk
+	retainSnapshots = 2
+	// maxPool is the raft transport connection pool size per peer
+	maxPool = 3
+)
+
+// ErrNotLeader is returned on writes sent to a follower, they must be sent
+// to the leader instead.
+var ErrNotLeader = errors.New("peer is not the leader")
+
+// Member is a swarm member as seen by raft
+type Member struct {
+	ID string `json:"id"`
+	// Address is the member host, its raft port is appended when missing
+	Address string `json:"address"`
+}
+
+// Config is the identity of a peer and the swarm it bootstraps
+type Config struct {
+	ID string
+	// Address is the host other peers reach this peer on
+	Address   string
+	RaftPort  int
+	AdminPort int
+	DataDir   string
+	// Peers are the initial voting members, the swarm is bootstrapped with
+	// them when they include this peer. Peers left out wait to be added.
+	Peers []Member
+}
+
+// Node is a replicated key value store member
+type Node struct {
+	config    Config
+	raft      *raft.Raft
+	store     *store
+	transport nodeTransport
+}
+
+// nodeTransport is the raft transport of a Node, closed on shutdown
+type nodeTransport interface {
+	raft.Transport
+	raft.WithClose
+}
+
+// NewNode starts the raft member described by config, bootstrapping the
+// swarm on first start when config lists it as initial member.
+func NewNode(config Config) (*Node, error) {
+	if config.ID == "" || config.Address == "" {
+		return nil, fmt.Errorf("peer id and address are required")
+	}
+	if err := os.MkdirAll(config.DataDir, 0o750); err != nil {
+		return nil, err
+	}
+
+	advertise, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(config.Address, strconv.Itoa(config.RaftPort)))
+	if err != nil {
+		return nil, fmt.Errorf("resolving advertise address: %v", err)
+	}
+	transport, err := raft.NewTCPTransport(fmt.Sprintf(":%d", config.RaftPort), advertise, maxPool, applyTimeout, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	boltStore, err := raftboltdb.NewBoltStore(filepath.Join(config.DataDir, "raft.db"))
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	snapshots, err := raft.NewFileSnapshotStore(config.DataDir, retainSnapshots, os.Stderr)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	return newNode(config, raft.DefaultConfig(), transport, boltStore, boltStore, snapshots)
+}
+
+// newNode starts the raft member described by config on transport and the
+// given stores, the transport is closed when it fails to start.
+func newNode(config Config, raftConfig *raft.Config, transport nodeTransport, logs raft.LogStore, stable raft.StableStore, snapshots raft.SnapshotStore) (*Node, error) {
+	raftConfig.LocalID = raft.ServerID(config.ID)
+
+	st := newStore()
+	r, err := raft.NewRaft(raftConfig, st, logs, stable, snapshots, transport)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	n := &Node{
+		config:    config,
+		raft:      r,
+		store:     st,
+		transport: transport,
+	}
+	if err := n.bootstrap(); err != nil {
+		_ = n.Shutdown()
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// bootstrap sets the initial configuration, a no-op once raft has state
+func (n *Node) bootstrap() error {
+	var servers []raft.Server
+	member := false
+	for _, p := range n.config.Peers {
+		if p.ID == n.config.ID {
+			member = true
+		}
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(p.ID),
+			Address: n.raftAddress(p.Address),
+		})
+	}
+	if !member {
+		return nil
+	}
+
+	err := n.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	if err != nil && err != raft.ErrCantBootstrap {
+		return err
+	}
+	return nil
+}
+
+// raftAddress appends the raft port to host unless it has one already
+func (n *Node) raftAddress(host string) raft.ServerAddress {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return raft.ServerAddress(host)
+	}
+	return raft.ServerAddress(net.JoinHostPort(host, strconv.Itoa(n.config.RaftPort)))
+}
+
+// Status is the consensus state of a peer
+type Status struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	Term  int64  `json:"term"`
+	// Leader is the leader raft address, LeaderID its peer ID
+	Leader   string         `json:"leader,omitempty"`
+	LeaderID string         `json:"leaderID,omitempty"`
+	Servers  []ServerStatus `json:"servers,omitempty"`
+	// CommitIndex is the last log entry known committed, AppliedIndex the
+	// last one applied on this peer. A learner caught up with the leader has
+	// applied what the leader committed.
+	CommitIndex  uint64 `json:"commitIndex"`
+	AppliedIndex uint64 `json:"appliedIndex"`
+}
+
+// ServerStatus is a member of the current raft configuration
+type ServerStatus struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+}
+
+// Status returns the consensus state of the peer
+func (n *Node) Status() (Status, error) {
+	stats := n.raft.Stats()
+	term, _ := strconv.ParseInt(stats["term"], 10, 64)
+	commitIndex, _ := strconv.ParseUint(stats["commit_index"], 10, 64)
+	leader, leaderID := n.raft.LeaderWithID()
+
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return Status{}, err
+	}
+	var servers []ServerStatus
+	for _, s := range future.Configuration().Servers {
+		servers = append(servers, ServerStatus{
+			ID:       string(s.ID),
+			Address:  string(s.Address),
+			Suffrage: s.Suffrage.String(),
+		})
+	}
+
+	return Status{
+		ID:       n.config.ID,
+		State:    n.raft.State().String(),
+		Term:     term,
+		Leader:   string(leader),
+		LeaderID: string(leaderID),
+		Servers:  servers,
+
+		CommitIndex:  commitIndex,
+		AppliedIndex: n.raft.AppliedIndex(),
+	}, nil
+}
+
+// LeaderAdminAddress returns the admin host:port of the current leader, empty
+// while there is none. Every peer serves its admin API on the same port.
+func (n *Node) LeaderAdminAddress() string {
+	leader, _ := n.raft.LeaderWithID()
+	if leader == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(string(leader))
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(n.config.AdminPort))
+}
+
+// Get reads key from the local state, it may be stale on followers
+func (n *Node) Get(key string) (string, bool) {
+	return n.store.get(key)
+}
+
+// Set replicates key set to value
+func (n *Node) Set(key, value string) error {
+	return n.apply(command{Op: opSet, Key: key, Value: value})
+}
+
+// Delete replicates key removal
+func (n *Node) Delete(key string) error {
+	return n.apply(command{Op: opDelete, Key: key})
+}
+
+func (n *Node) apply(cmd command) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	future := n.raft.Apply(data, applyTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Join adds the member to the swarm, as voter or as non voting learner
+func (n *Node) Join(m Member, voter bool) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	if voter {
+		return n.raft.AddVoter(raft.ServerID(m.ID), n.raftAddress(m.Address), 0, applyTimeout).Error()
+	}
+	return n.raft.AddNonvoter(raft.ServerID(m.ID), n.raftAddress(m.Address), 0, applyTimeout).Error()
+}
+
+// Leave removes the member id from the swarm
+func (n *Node) Leave(id string) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	return n.raft.RemoveServer(raft.ServerID(id), 0, applyTimeout).Error()
+}
+
+// Transfer hands leadership over to the member id, its address is taken
+// from the raft configuration.
+func (n *Node) Transfer(id string) error {
+	if n.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	for _, s := range future.Configuration().Servers {
+		if string(s.ID) == id {
+			return n.raft.LeadershipTransferToServer(s.ID, s.Address).Error()
+		}
+	}
+	return fmt.Errorf("peer %s is not a swarm member", id)
+}
+
+// Shutdown stops the raft member, leaving the swarm configuration untouched
+func (n *Node) Shutdown() error {
+	err := n.raft.Shutdown().Error()
+	if cerr := n.transport.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
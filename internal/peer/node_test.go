@@ -0,0 +1,207 @@
+package peer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const testRaftPort = 7000
+
+// testSwarm runs nodes on connected in memory transports and stores
+type testSwarm struct {
+	t          *testing.T
+	nodes      map[string]*Node
+	transports map[raft.ServerAddress]*raft.InmemTransport
+}
+
+func newTestSwarm(t *testing.T) *testSwarm {
+	return &testSwarm{t: t, nodes: map[string]*Node{}, transports: map[raft.ServerAddress]*raft.InmemTransport{}}
+}
+
+// start runs the node id, bootstrapping with members when they include it
+func (s *testSwarm) start(id string, members []Member) *Node {
+	s.t.Helper()
+
+	config := Config{ID: id, Address: id, RaftPort: testRaftPort, AdminPort: 8080, Peers: members}
+	addr, transport := raft.NewInmemTransport(raft.ServerAddress(fmt.Sprintf("%s:%d", id, testRaftPort)))
+	for peerAddr, peer := range s.transports {
+		transport.Connect(peerAddr, peer)
+		peer.Connect(addr, transport)
+	}
+	s.transports[addr] = transport
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.HeartbeatTimeout = 200 * time.Millisecond
+	raftConfig.ElectionTimeout = 200 * time.Millisecond
+	raftConfig.LeaderLeaseTimeout = 100 * time.Millisecond
+	raftConfig.CommitTimeout = 5 * time.Millisecond
+	raftConfig.LogOutput = ioutil.Discard
+
+	store := raft.NewInmemStore()
+	node, err := newNode(config, raftConfig, transport, store, store, raft.NewInmemSnapshotStore())
+	if err != nil {
+		s.t.Fatalf("starting %s: %v", id, err)
+	}
+	s.t.Cleanup(func() { _ = node.Shutdown() })
+	s.nodes[id] = node
+	return node
+}
+
+// leader waits for a single node to lead and returns it
+func (s *testSwarm) leader() *Node {
+	s.t.Helper()
+	var leader *Node
+	eventually(s.t, "a leader elected", func() bool {
+		leader = nil
+		for _, node := range s.nodes {
+			if node.raft.State() != raft.Leader {
+				continue
+			}
+			if leader != nil {
+				return false
+			}
+			leader = node
+		}
+		return leader != nil
+	})
+	return leader
+}
+
+// follower returns a node other than the leader
+func (s *testSwarm) follower(leader *Node) *Node {
+	for _, node := range s.nodes {
+		if node != leader {
+			return node
+		}
+	}
+	s.t.Fatal("no follower")
+	return nil
+}
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// suffrage returns the suffrage of id on the node configuration, empty when
+// it is not a member
+func suffrage(t *testing.T, node *Node, id string) string {
+	t.Helper()
+	status, err := node.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, server := range status.Servers {
+		if server.ID == id {
+			return server.Suffrage
+		}
+	}
+	return ""
+}
+
+func TestNodeMembership(t *testing.T) {
+	s := newTestSwarm(t)
+	members := []Member{{ID: "a", Address: "a"}, {ID: "b", Address: "b"}, {ID: "c", Address: "c"}}
+	for _, m := range members {
+		s.start(m.ID, members)
+	}
+
+	// bootstrap
+	leader := s.leader()
+	for _, m := range members {
+		if got := suffrage(t, leader, m.ID); got != "Voter" {
+			t.Errorf("expected %s bootstrapped as voter, got %q", m.ID, got)
+		}
+	}
+	if err := leader.Set("key", "value"); err != nil {
+		t.Fatalf("unexpected set error: %v", err)
+	}
+	follower := s.follower(leader)
+	if err := follower.Set("key", "other"); err != ErrNotLeader {
+		t.Errorf("expected writes on a follower refused, got %v", err)
+	}
+	eventually(t, "the follower to know the leader", func() bool {
+		status, err := follower.Status()
+		return err == nil && status.LeaderID == leader.config.ID
+	})
+
+	// join as learner, a node started without members waits to be added
+	d := s.start("d", nil)
+	if err := follower.Join(Member{ID: "d", Address: "d"}, false); err != ErrNotLeader {
+		t.Errorf("expected joins on a follower refused, got %v", err)
+	}
+	if err := leader.Join(Member{ID: "d", Address: "d"}, false); err != nil {
+		t.Fatalf("unexpected join error: %v", err)
+	}
+	if got := suffrage(t, leader, "d"); got != "Nonvoter" {
+		t.Errorf("expected d joined as nonvoter, got %q", got)
+	}
+	eventually(t, "d to replicate the log", func() bool {
+		value, ok := d.Get("key")
+		return ok && value == "value"
+	})
+	eventually(t, "d to catch up with the leader", func() bool {
+		leaderStatus, err := leader.Status()
+		if err != nil {
+			return false
+		}
+		status, err := d.Status()
+		return err == nil && status.AppliedIndex >= leaderStatus.CommitIndex
+	})
+
+	// joining again as voter promotes
+	if err := leader.Join(Member{ID: "d", Address: "d"}, true); err != nil {
+		t.Fatalf("unexpected promotion error: %v", err)
+	}
+	if got := suffrage(t, leader, "d"); got != "Voter" {
+		t.Errorf("expected d promoted to voter, got %q", got)
+	}
+
+	// transfer
+	if err := leader.Transfer("unknown"); err == nil {
+		t.Error("expected a transfer to a non member refused")
+	}
+	if err := leader.Transfer("d"); err != nil {
+		t.Fatalf("unexpected transfer error: %v", err)
+	}
+	eventually(t, "d to lead after the transfer", func() bool {
+		return s.leader() == d
+	})
+	eventually(t, "the swarm to follow d", func() bool {
+		for _, node := range s.nodes {
+			status, err := node.Status()
+			if err != nil || status.LeaderID != "d" {
+				return false
+			}
+		}
+		return true
+	})
+	if got := d.LeaderAdminAddress(); got != "d:8080" {
+		t.Errorf("expected the leader admin address d:8080, got %q", got)
+	}
+
+	// leave
+	if err := d.Leave("a"); err != nil {
+		t.Fatalf("unexpected leave error: %v", err)
+	}
+	if got := suffrage(t, d, "a"); got != "" {
+		t.Errorf("expected a removed, got %q", got)
+	}
+	if err := d.Set("key", "after"); err != nil {
+		t.Fatalf("expected writes once a left, got %v", err)
+	}
+	eventually(t, "b to replicate writes after a left", func() bool {
+		value, _ := s.nodes["b"].Get("key")
+		return value == "after"
+	})
+}
@@ -1,6 +1,7 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -13,6 +14,28 @@ const (
 	PhaseDone    = "DONE"
 )
 
+// ConditionZoneQuorum is True when a single zone holds a quorum of peers, so
+// losing that zone loses the swarm.
+const ConditionZoneQuorum = "SingleZoneQuorum"
+
+// ConditionScaling is True while the owned peers differ from Spec.Replicas
+const ConditionScaling = "Scaling"
+
+// ConditionQuorumLost is True when healthy voting peers dropped below the
+// quorum the swarm held before
+const ConditionQuorumLost = "QuorumLost"
+
+// Peer roles in the swarm consensus, voters and witnesses make the quorum.
+// New voters join as learners and are promoted once caught up.
+const (
+	// RoleVoter is a full voting member
+	RoleVoter = "Voter"
+	// RoleLearner replicates the log without voting
+	RoleLearner = "Learner"
+	// RoleWitness votes without replicating data
+	RoleWitness = "Witness"
+)
+
 // PeerStatus defines the observed state of Peer
 type PeerStatus struct {
 	// Phase represents the state of the schedule: until the command is executed
@@ -31,6 +54,8 @@ type Peer struct {
 	Address           string     `json:"address"`
 	CreatedAt         int64      `json:"created_at"`
 	State             PeerStatus `json:"state"`
+	// Role overrides the role the peer index gets from the spec counts
+	Role string `json:"role,omitempty"`
 }
 
 // SwarmSpec defines the desired state of Swarm
@@ -38,6 +63,189 @@ type SwarmSpec struct {
 	Replicas int    `json:"replicas"`
 	Size     int    `json:"size"`
 	Peers    []Peer `json:"peers,omitempty"`
+	// Learners is how many peers replicate without voting, clients may route
+	// reads to them.
+	Learners int `json:"learners,omitempty"`
+	// Witnesses is how many peers vote without holding data
+	Witnesses int `json:"witnesses,omitempty"`
+	// Selector is a label query over the pods owned by the Swarm, matching
+	// orphans are adopted and owned pods no longer matching are released.
+	// Defaults to the k8slab.info/swarm label set to the Swarm name. Adopted
+	// pods get that label, orphans labeled for another Swarm are never
+	// adopted.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// VolumeClaimTemplates are the claims every peer gets, one claim per
+	// template and peer index, so a recreated peer reattaches to its data.
+	VolumeClaimTemplates []corev1.PersistentVolumeClaim `json:"volumeClaimTemplates,omitempty"`
+	// VolumeClaimRetention decides what happens to peer claims on scale down
+	// and on Swarm deletion.
+	VolumeClaimRetention VolumeClaimRetentionPolicy `json:"volumeClaimRetention,omitempty"`
+	// DisableDisruptionBudget opts out of the PodDisruptionBudget keeping a
+	// quorum of peers available during voluntary disruptions.
+	DisableDisruptionBudget bool `json:"disableDisruptionBudget,omitempty"`
+	// Placement constrains where peers are scheduled
+	Placement *Placement `json:"placement,omitempty"`
+	// Template describes the peer pods, a busybox placeholder when nil
+	Template *corev1.PodTemplateSpec `json:"template,omitempty"`
+	// UpdateStrategy decides how peers are replaced when Template changes
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+	// Healing decides when unhealthy peers are replaced
+	Healing HealingPolicy `json:"healing,omitempty"`
+	// Endpoints are the peer HTTP endpoints the controller talks to
+	Endpoints PeerEndpoints `json:"endpoints,omitempty"`
+	// AddressPool allocates peer addresses, unique across every swarm,
+	// instead of assigning them by hand on Peers
+	AddressPool *AddressPool `json:"addressPool,omitempty"`
+}
+
+// AddressPool is an IPv4 or IPv6 range peer addresses are allocated from
+type AddressPool struct {
+	// CIDR is the range addresses are allocated from, as 10.9.9.0/24
+	CIDR string `json:"cidr"`
+	// Exclude lists addresses never allocated, as single addresses, CIDRs or
+	// first-last address ranges
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// PeerEndpoints locates the HTTP endpoints served by every peer pod
+type PeerEndpoints struct {
+	// Status reports the peer consensus state, leader discovery is enabled
+	// when set.
+	Status *StatusEndpoint `json:"status,omitempty"`
+	// Transfer is the admin endpoint asking the leader to hand leadership
+	// over before it is removed or restarted, it needs Status to observe
+	// the new leader.
+	Transfer *TransferEndpoint `json:"transfer,omitempty"`
+}
+
+// HTTPEndpoint is an HTTP endpoint served on the peer pod IP
+type HTTPEndpoint struct {
+	Port int32  `json:"port"`
+	Path string `json:"path,omitempty"`
+}
+
+// StatusEndpoint is the peer status endpoint, answering with the peer ID,
+// its state, its term and the leader it knows of as JSON.
+type StatusEndpoint struct {
+	HTTPEndpoint `json:",inline"`
+	// PeriodSeconds is how often peers are queried, ten seconds when unset
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+}
+
+// TransferEndpoint is the leader admin endpoint, POSTed with the ID and
+// address of the peer taking leadership over as JSON.
+type TransferEndpoint struct {
+	HTTPEndpoint `json:",inline"`
+	// TimeoutSeconds is how long to wait for a new leader before removing
+	// the leader anyway, thirty seconds when unset
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// HealingPolicy replaces peers staying unhealthy, a replacement keeps the
+// peer index and joins the Pool with a new peer ID.
+type HealingPolicy struct {
+	// Disabled leaves unhealthy peers in place
+	Disabled bool `json:"disabled,omitempty"`
+	// UnhealthyAfter is how long a peer may stay failed or unready before
+	// being replaced, five minutes when unset.
+	UnhealthyAfter *metav1.Duration `json:"unhealthyAfter,omitempty"`
+}
+
+type UpdateStrategyType string
+
+const (
+	// RollingUpdateStrategy replaces peers one at a time, the default
+	RollingUpdateStrategy = UpdateStrategyType("RollingUpdate")
+	// OnDeleteStrategy replaces peers only when they are deleted
+	OnDeleteStrategy = UpdateStrategyType("OnDelete")
+)
+
+// UpdateStrategy defines how peers move to a new revision
+type UpdateStrategy struct {
+	Type UpdateStrategyType `json:"type,omitempty"`
+	// Partition stages rolling updates, only peers with an index greater or
+	// equal to the partition are replaced.
+	Partition int `json:"partition,omitempty"`
+}
+
+type AntiAffinityMode string
+
+const (
+	// RequiredAntiAffinity never schedules two peers on the same node
+	RequiredAntiAffinity = AntiAffinityMode("Required")
+	// PreferredAntiAffinity spreads peers across nodes when possible
+	PreferredAntiAffinity = AntiAffinityMode("Preferred")
+)
+
+// Placement defines the scheduling policy of peers, spreading them so a
+// single node or zone failure does not take down a quorum.
+type Placement struct {
+	// NodeAntiAffinity spreads peers across nodes, none when empty
+	NodeAntiAffinity AntiAffinityMode `json:"nodeAntiAffinity,omitempty"`
+	// ZoneSpread spreads peers across zones, none when nil
+	ZoneSpread *ZoneSpread `json:"zoneSpread,omitempty"`
+	// NodeSelector restricts peers to nodes with matching labels
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are added to every peer
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+}
+
+// ZoneSpread is a topology spread constraint of peers over zones
+type ZoneSpread struct {
+	// MaxSkew is the allowed peer count difference between zones
+	MaxSkew int32 `json:"maxSkew"`
+	// WhenUnsatisfiable is DoNotSchedule or ScheduleAnyway
+	WhenUnsatisfiable corev1.UnsatisfiableConstraintAction `json:"whenUnsatisfiable,omitempty"`
+}
+
+type VolumeClaimRetentionPolicyType string
+
+const (
+	// RetainVolumeClaimRetentionPolicy keeps peer claims, the default
+	RetainVolumeClaimRetentionPolicy = VolumeClaimRetentionPolicyType("Retain")
+	// DeleteVolumeClaimRetentionPolicy removes peer claims
+	DeleteVolumeClaimRetentionPolicy = VolumeClaimRetentionPolicyType("Delete")
+)
+
+// VolumeClaimRetentionPolicy defines the lifecycle of peer claims
+type VolumeClaimRetentionPolicy struct {
+	// WhenDeleted applies to claims when the Swarm is deleted
+	WhenDeleted VolumeClaimRetentionPolicyType `json:"whenDeleted,omitempty"`
+	// WhenScaled applies to claims of peers removed by a scale down
+	WhenScaled VolumeClaimRetentionPolicyType `json:"whenScaled,omitempty"`
+}
+
+// LeaderTransfer records a leadership hand over asked to the leader pod From
+// towards the peer TargetID running on pod To.
+type LeaderTransfer struct {
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	TargetID  string      `json:"targetID"`
+	StartedAt metav1.Time `json:"startedAt"`
+}
+
+// PeerReplacement records an unhealthy peer replaced on its index
+type PeerReplacement struct {
+	Index      int         `json:"index"`
+	OldID      string      `json:"oldID"`
+	NewID      string      `json:"newID"`
+	Reason     string      `json:"reason,omitempty"`
+	ReplacedAt metav1.Time `json:"replacedAt"`
+	// CompletedAt is set once the replacement peer joined the Pool
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// PeerAddress is an address allocated to the peer index from the pool
+type PeerAddress struct {
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+}
+
+// PeerVolumeStatus is the binding state of a peer claim
+type PeerVolumeStatus struct {
+	Index int                               `json:"index"`
+	Claim string                            `json:"claim"`
+	Phase corev1.PersistentVolumeClaimPhase `json:"phase,omitempty"`
 }
 
 // SwarmStatus defines the observed state of Swarm
@@ -45,10 +253,48 @@ type SwarmStatus struct {
 	// Phase represents the state of the schedule: until the command is executed
 	// it is PENDING, afterwards it is DONE.
 	Phase string `json:"phase,omitempty"`
+	// Replicas is the number of peer pods owned by the Swarm, read by the
+	// scale subresource.
+	Replicas int32 `json:"replicas"`
+	// Selector is the serialized peer pods label selector, read by the scale
+	// subresource so autoscalers can find the Swarm pods.
+	Selector string `json:"selector,omitempty"`
+	// Volumes reports the binding state of every peer claim
+	Volumes []PeerVolumeStatus `json:"volumes,omitempty"`
+	// Nodes is the number of peers scheduled on each node
+	Nodes map[string]int32 `json:"nodes,omitempty"`
+	// Zones is the number of peers scheduled on each zone
+	Zones map[string]int32 `json:"zones,omitempty"`
+	// Conditions are the latest observations of the Swarm state
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// CurrentRevision is the revision all peers ran before the latest update
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	// UpdateRevision is the revision built from the current spec
+	UpdateRevision string `json:"updateRevision,omitempty"`
+	// UpdatedReplicas is the number of peers running UpdateRevision
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// Leader is the peer pod leading the swarm, empty while unknown
+	Leader string `json:"leader,omitempty"`
+	// LeaderID is the peer ID of the leader
+	LeaderID string `json:"leaderID,omitempty"`
+	// Term is the highest consensus term reported by the peers
+	Term int64 `json:"term,omitempty"`
+	// LeaderTransfer is the leadership hand over in progress, if any
+	LeaderTransfer *LeaderTransfer `json:"leaderTransfer,omitempty"`
+	// Replacements is the latest history of peers replaced by healing
+	Replacements []PeerReplacement `json:"replacements,omitempty"`
+	// Voters, Learners and Witnesses count the peers holding each role
+	Voters    int32 `json:"voters,omitempty"`
+	Learners  int32 `json:"learners,omitempty"`
+	Witnesses int32 `json:"witnesses,omitempty"`
+	// Addresses are the peer addresses allocated from the address pool
+	Addresses []PeerAddress `json:"addresses,omitempty"`
 	// Important: Run "make" to regenerate code after modifying this file
 }
 
 // +genclient
+// +genclient:method=GetScale,verb=get,subresource=scale,result=k8s.io/api/autoscaling/v1.Scale
+// +genclient:method=UpdateScale,verb=update,subresource=scale,input=k8s.io/api/autoscaling/v1.Scale,result=k8s.io/api/autoscaling/v1.Scale
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // Swarm runs a command Swarm a given schedule.
@@ -68,3 +314,47 @@ type SwarmList struct {
 	metav1.ListMeta `json:"metadata,omitempty"`
 	Items           []Swarm `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SwarmPeer is a single member of a Swarm, owned by it and named after its
+// peer pod, so that members can be watched and updated one by one.
+type SwarmPeer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SwarmPeerSpec   `json:"spec,omitempty"`
+	Status SwarmPeerStatus `json:"status,omitempty"`
+}
+
+// SwarmPeerSpec defines the member a SwarmPeer stands for
+type SwarmPeerSpec struct {
+	// Swarm is the name of the owner Swarm
+	Swarm string `json:"swarm"`
+	// Index is the peer index of the member, its peer pod ordinal
+	Index int32 `json:"index"`
+	// ID is the peer ID the member joins the Pool with
+	ID string `json:"id"`
+}
+
+// SwarmPeerStatus defines the observed state of a SwarmPeer
+type SwarmPeerStatus struct {
+	// Phase is the phase of the peer pod
+	Phase corev1.PodPhase `json:"phase,omitempty"`
+	// Role is the peer part in the swarm consensus
+	Role string `json:"role,omitempty"`
+	// Address is the peer pod IP, empty until scheduled
+	Address string `json:"address,omitempty"`
+	// Healthy is true while the peer pod is running and ready
+	Healthy bool `json:"healthy"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SwarmPeerList contains a list of SwarmPeer
+type SwarmPeerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SwarmPeer `json:"items"`
+}
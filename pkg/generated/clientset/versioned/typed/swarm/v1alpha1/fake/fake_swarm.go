@@ -22,6 +22,7 @@ import (
 	"context"
 
 	v1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	labels "k8s.io/apimachinery/pkg/labels"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
@@ -140,3 +141,25 @@ func (c *FakeSwarms) Patch(ctx context.Context, name string, pt types.PatchType,
 	}
 	return obj.(*v1alpha1.Swarm), err
 }
+
+// GetScale takes name of the swarm, and returns the corresponding scale object, and an error if there is any.
+func (c *FakeSwarms) GetScale(ctx context.Context, swarmName string, options v1.GetOptions) (result *autoscalingv1.Scale, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetSubresourceAction(swarmsResource, c.ns, "scale", swarmName), &autoscalingv1.Scale{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*autoscalingv1.Scale), err
+}
+
+// UpdateScale takes the representation of a scale and updates it. Returns the server's representation of the scale, and an error, if there is any.
+func (c *FakeSwarms) UpdateScale(ctx context.Context, swarmName string, scale *autoscalingv1.Scale, opts v1.UpdateOptions) (result *autoscalingv1.Scale, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(swarmsResource, "scale", c.ns, scale), &autoscalingv1.Scale{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*autoscalingv1.Scale), err
+}
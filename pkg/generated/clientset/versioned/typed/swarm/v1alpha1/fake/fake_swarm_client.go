@@ -32,6 +32,10 @@ func (c *FakeK8slabV1alpha1) Swarms(namespace string) v1alpha1.SwarmInterface {
 	return &FakeSwarms{c, namespace}
 }
 
+func (c *FakeK8slabV1alpha1) SwarmPeers(namespace string) v1alpha1.SwarmPeerInterface {
+	return &FakeSwarmPeers{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeK8slabV1alpha1) RESTClient() rest.Interface {
@@ -0,0 +1,142 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package fake
+
+import (
+	"context"
+
+	v1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	labels "k8s.io/apimachinery/pkg/labels"
+	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	testing "k8s.io/client-go/testing"
+)
+
+// FakeSwarmPeers implements SwarmPeerInterface
+type FakeSwarmPeers struct {
+	Fake *FakeK8slabV1alpha1
+	ns   string
+}
+
+var swarmpeersResource = schema.GroupVersionResource{Group: "k8slab.info", Version: "v1alpha1", Resource: "swarmpeers"}
+
+var swarmpeersKind = schema.GroupVersionKind{Group: "k8slab.info", Version: "v1alpha1", Kind: "SwarmPeer"}
+
+// Get takes name of the swarmPeer, and returns the corresponding swarmPeer object, and an error if there is any.
+func (c *FakeSwarmPeers) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.SwarmPeer, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewGetAction(swarmpeersResource, c.ns, name), &v1alpha1.SwarmPeer{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.SwarmPeer), err
+}
+
+// List takes label and field selectors, and returns the list of SwarmPeers that match those selectors.
+func (c *FakeSwarmPeers) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.SwarmPeerList, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewListAction(swarmpeersResource, swarmpeersKind, c.ns, opts), &v1alpha1.SwarmPeerList{})
+
+	if obj == nil {
+		return nil, err
+	}
+
+	label, _, _ := testing.ExtractFromListOptions(opts)
+	if label == nil {
+		label = labels.Everything()
+	}
+	list := &v1alpha1.SwarmPeerList{ListMeta: obj.(*v1alpha1.SwarmPeerList).ListMeta}
+	for _, item := range obj.(*v1alpha1.SwarmPeerList).Items {
+		if label.Matches(labels.Set(item.Labels)) {
+			list.Items = append(list.Items, item)
+		}
+	}
+	return list, err
+}
+
+// Watch returns a watch.Interface that watches the requested swarmPeers.
+func (c *FakeSwarmPeers) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	return c.Fake.
+		InvokesWatch(testing.NewWatchAction(swarmpeersResource, c.ns, opts))
+
+}
+
+// Create takes the representation of a swarmPeer and creates it.  Returns the server's representation of the swarmPeer, and an error, if there is any.
+func (c *FakeSwarmPeers) Create(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.CreateOptions) (result *v1alpha1.SwarmPeer, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewCreateAction(swarmpeersResource, c.ns, swarmPeer), &v1alpha1.SwarmPeer{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.SwarmPeer), err
+}
+
+// Update takes the representation of a swarmPeer and updates it. Returns the server's representation of the swarmPeer, and an error, if there is any.
+func (c *FakeSwarmPeers) Update(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.UpdateOptions) (result *v1alpha1.SwarmPeer, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateAction(swarmpeersResource, c.ns, swarmPeer), &v1alpha1.SwarmPeer{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.SwarmPeer), err
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeSwarmPeers) UpdateStatus(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.UpdateOptions) (*v1alpha1.SwarmPeer, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(swarmpeersResource, "status", c.ns, swarmPeer), &v1alpha1.SwarmPeer{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.SwarmPeer), err
+}
+
+// Delete takes name of the swarmPeer and deletes it. Returns an error if one occurs.
+func (c *FakeSwarmPeers) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	_, err := c.Fake.
+		Invokes(testing.NewDeleteAction(swarmpeersResource, c.ns, name), &v1alpha1.SwarmPeer{})
+
+	return err
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *FakeSwarmPeers) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(swarmpeersResource, c.ns, listOpts)
+
+	_, err := c.Fake.Invokes(action, &v1alpha1.SwarmPeerList{})
+	return err
+}
+
+// Patch applies the patch and returns the patched swarmPeer.
+func (c *FakeSwarmPeers) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.SwarmPeer, err error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewPatchSubresourceAction(swarmpeersResource, c.ns, name, pt, data, subresources...), &v1alpha1.SwarmPeer{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.SwarmPeer), err
+}
@@ -27,6 +27,7 @@ import (
 type K8slabV1alpha1Interface interface {
 	RESTClient() rest.Interface
 	SwarmsGetter
+	SwarmPeersGetter
 }
 
 // K8slabV1alpha1Client is used to interact with features provided by the k8slab.info group.
@@ -38,6 +39,10 @@ func (c *K8slabV1alpha1Client) Swarms(namespace string) SwarmInterface {
 	return newSwarms(c, namespace)
 }
 
+func (c *K8slabV1alpha1Client) SwarmPeers(namespace string) SwarmPeerInterface {
+	return newSwarmPeers(c, namespace)
+}
+
 // NewForConfig creates a new K8slabV1alpha1Client for the given config.
 func NewForConfig(c *rest.Config) (*K8slabV1alpha1Client, error) {
 	config := *c
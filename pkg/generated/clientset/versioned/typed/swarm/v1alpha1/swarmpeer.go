@@ -0,0 +1,195 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by client-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	v1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	scheme "github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned/scheme"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	types "k8s.io/apimachinery/pkg/types"
+	watch "k8s.io/apimachinery/pkg/watch"
+	rest "k8s.io/client-go/rest"
+)
+
+// SwarmPeersGetter has a method to return a SwarmPeerInterface.
+// A group's client should implement this interface.
+type SwarmPeersGetter interface {
+	SwarmPeers(namespace string) SwarmPeerInterface
+}
+
+// SwarmPeerInterface has methods to work with SwarmPeer resources.
+type SwarmPeerInterface interface {
+	Create(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.CreateOptions) (*v1alpha1.SwarmPeer, error)
+	Update(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.UpdateOptions) (*v1alpha1.SwarmPeer, error)
+	UpdateStatus(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.UpdateOptions) (*v1alpha1.SwarmPeer, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1alpha1.SwarmPeer, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1alpha1.SwarmPeerList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.SwarmPeer, err error)
+	SwarmPeerExpansion
+}
+
+// swarmPeers implements SwarmPeerInterface
+type swarmPeers struct {
+	client rest.Interface
+	ns     string
+}
+
+// newSwarmPeers returns a SwarmPeers
+func newSwarmPeers(c *K8slabV1alpha1Client, namespace string) *swarmPeers {
+	return &swarmPeers{
+		client: c.RESTClient(),
+		ns:     namespace,
+	}
+}
+
+// Get takes name of the swarmPeer, and returns the corresponding swarmPeer object, and an error if there is any.
+func (c *swarmPeers) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.SwarmPeer, err error) {
+	result = &v1alpha1.SwarmPeer{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		Name(name).
+		VersionedParams(&options, scheme.ParameterCodec).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// List takes label and field selectors, and returns the list of SwarmPeers that match those selectors.
+func (c *swarmPeers) List(ctx context.Context, opts v1.ListOptions) (result *v1alpha1.SwarmPeerList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result = &v1alpha1.SwarmPeerList{}
+	err = c.client.Get().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Watch returns a watch.Interface that watches the requested swarmPeers.
+func (c *swarmPeers) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.client.Get().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Watch(ctx)
+}
+
+// Create takes the representation of a swarmPeer and creates it.  Returns the server's representation of the swarmPeer, and an error, if there is any.
+func (c *swarmPeers) Create(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.CreateOptions) (result *v1alpha1.SwarmPeer, err error) {
+	result = &v1alpha1.SwarmPeer{}
+	err = c.client.Post().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(swarmPeer).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Update takes the representation of a swarmPeer and updates it. Returns the server's representation of the swarmPeer, and an error, if there is any.
+func (c *swarmPeers) Update(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.UpdateOptions) (result *v1alpha1.SwarmPeer, err error) {
+	result = &v1alpha1.SwarmPeer{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		Name(swarmPeer.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(swarmPeer).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *swarmPeers) UpdateStatus(ctx context.Context, swarmPeer *v1alpha1.SwarmPeer, opts v1.UpdateOptions) (result *v1alpha1.SwarmPeer, err error) {
+	result = &v1alpha1.SwarmPeer{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		Name(swarmPeer.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(swarmPeer).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Delete takes name of the swarmPeer and deletes it. Returns an error if one occurs.
+func (c *swarmPeers) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		Name(name).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *swarmPeers) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
+		Error()
+}
+
+// Patch applies the patch and returns the patched swarmPeer.
+func (c *swarmPeers) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1alpha1.SwarmPeer, err error) {
+	result = &v1alpha1.SwarmPeer{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("swarmpeers").
+		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
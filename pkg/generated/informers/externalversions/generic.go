@@ -55,6 +55,8 @@ func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource
 	// Group=k8slab.info, Version=v1alpha1
 	case v1alpha1.SchemeGroupVersion.WithResource("swarms"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.K8slab().V1alpha1().Swarms().Informer()}, nil
+	case v1alpha1.SchemeGroupVersion.WithResource("swarmpeers"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.K8slab().V1alpha1().SwarmPeers().Informer()}, nil
 
 	}
 
@@ -26,6 +26,8 @@ import (
 type Interface interface {
 	// Swarms returns a SwarmInformer.
 	Swarms() SwarmInformer
+	// SwarmPeers returns a SwarmPeerInformer.
+	SwarmPeers() SwarmPeerInformer
 }
 
 type version struct {
@@ -43,3 +45,8 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 func (v *version) Swarms() SwarmInformer {
 	return &swarmInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// SwarmPeers returns a SwarmPeerInformer.
+func (v *version) SwarmPeers() SwarmPeerInformer {
+	return &swarmPeerInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
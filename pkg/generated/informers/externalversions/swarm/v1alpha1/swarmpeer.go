@@ -0,0 +1,90 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by informer-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"context"
+	time "time"
+
+	swarmv1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	versioned "github.com/marcosQuesada/swarm/pkg/generated/clientset/versioned"
+	internalinterfaces "github.com/marcosQuesada/swarm/pkg/generated/informers/externalversions/internalinterfaces"
+	v1alpha1 "github.com/marcosQuesada/swarm/pkg/generated/listers/swarm/v1alpha1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+	watch "k8s.io/apimachinery/pkg/watch"
+	cache "k8s.io/client-go/tools/cache"
+)
+
+// SwarmPeerInformer provides access to a shared informer and lister for
+// SwarmPeers.
+type SwarmPeerInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() v1alpha1.SwarmPeerLister
+}
+
+type swarmPeerInformer struct {
+	factory          internalinterfaces.SharedInformerFactory
+	tweakListOptions internalinterfaces.TweakListOptionsFunc
+	namespace        string
+}
+
+// NewSwarmPeerInformer constructs a new informer for SwarmPeer type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewSwarmPeerInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return NewFilteredSwarmPeerInformer(client, namespace, resyncPeriod, indexers, nil)
+}
+
+// NewFilteredSwarmPeerInformer constructs a new informer for SwarmPeer type.
+// Always prefer using an informer factory to get a shared informer instead of getting an independent
+// one. This reduces memory footprint and number of connections to the server.
+func NewFilteredSwarmPeerInformer(client versioned.Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers, tweakListOptions internalinterfaces.TweakListOptionsFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.K8slabV1alpha1().SwarmPeers(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+				if tweakListOptions != nil {
+					tweakListOptions(&options)
+				}
+				return client.K8slabV1alpha1().SwarmPeers(namespace).Watch(context.TODO(), options)
+			},
+		},
+		&swarmv1alpha1.SwarmPeer{},
+		resyncPeriod,
+		indexers,
+	)
+}
+
+func (f *swarmPeerInformer) defaultInformer(client versioned.Interface, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return NewFilteredSwarmPeerInformer(client, f.namespace, resyncPeriod, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, f.tweakListOptions)
+}
+
+func (f *swarmPeerInformer) Informer() cache.SharedIndexInformer {
+	return f.factory.InformerFor(&swarmv1alpha1.SwarmPeer{}, f.defaultInformer)
+}
+
+func (f *swarmPeerInformer) Lister() v1alpha1.SwarmPeerLister {
+	return v1alpha1.NewSwarmPeerLister(f.Informer().GetIndexer())
+}
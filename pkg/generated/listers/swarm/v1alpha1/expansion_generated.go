@@ -25,3 +25,11 @@ type SwarmListerExpansion interface{}
 // SwarmNamespaceListerExpansion allows custom methods to be added to
 // SwarmNamespaceLister.
 type SwarmNamespaceListerExpansion interface{}
+
+// SwarmPeerListerExpansion allows custom methods to be added to
+// SwarmPeerLister.
+type SwarmPeerListerExpansion interface{}
+
+// SwarmPeerNamespaceListerExpansion allows custom methods to be added to
+// SwarmPeerNamespaceLister.
+type SwarmPeerNamespaceListerExpansion interface{}
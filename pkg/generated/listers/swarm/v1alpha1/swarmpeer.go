@@ -0,0 +1,99 @@
+/*
+Copyright The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by lister-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	v1alpha1 "github.com/marcosQuesada/swarm/pkg/apis/swarm/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SwarmPeerLister helps list SwarmPeers.
+// All objects returned here must be treated as read-only.
+type SwarmPeerLister interface {
+	// List lists all SwarmPeers in the indexer.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.SwarmPeer, err error)
+	// SwarmPeers returns an object that can list and get SwarmPeers.
+	SwarmPeers(namespace string) SwarmPeerNamespaceLister
+	SwarmPeerListerExpansion
+}
+
+// swarmPeerLister implements the SwarmPeerLister interface.
+type swarmPeerLister struct {
+	indexer cache.Indexer
+}
+
+// NewSwarmPeerLister returns a new SwarmPeerLister.
+func NewSwarmPeerLister(indexer cache.Indexer) SwarmPeerLister {
+	return &swarmPeerLister{indexer: indexer}
+}
+
+// List lists all SwarmPeers in the indexer.
+func (s *swarmPeerLister) List(selector labels.Selector) (ret []*v1alpha1.SwarmPeer, err error) {
+	err = cache.ListAll(s.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.SwarmPeer))
+	})
+	return ret, err
+}
+
+// SwarmPeers returns an object that can list and get SwarmPeers.
+func (s *swarmPeerLister) SwarmPeers(namespace string) SwarmPeerNamespaceLister {
+	return swarmPeerNamespaceLister{indexer: s.indexer, namespace: namespace}
+}
+
+// SwarmPeerNamespaceLister helps list and get SwarmPeers.
+// All objects returned here must be treated as read-only.
+type SwarmPeerNamespaceLister interface {
+	// List lists all SwarmPeers in the indexer for a given namespace.
+	// Objects returned here must be treated as read-only.
+	List(selector labels.Selector) (ret []*v1alpha1.SwarmPeer, err error)
+	// Get retrieves the SwarmPeer from the indexer for a given namespace and name.
+	// Objects returned here must be treated as read-only.
+	Get(name string) (*v1alpha1.SwarmPeer, error)
+	SwarmPeerNamespaceListerExpansion
+}
+
+// swarmPeerNamespaceLister implements the SwarmPeerNamespaceLister
+// interface.
+type swarmPeerNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all SwarmPeers in the indexer for a given namespace.
+func (s swarmPeerNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.SwarmPeer, err error) {
+	err = cache.ListAllByNamespace(s.indexer, s.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.SwarmPeer))
+	})
+	return ret, err
+}
+
+// Get retrieves the SwarmPeer from the indexer for a given namespace and name.
+func (s swarmPeerNamespaceLister) Get(name string) (*v1alpha1.SwarmPeer, error) {
+	obj, exists, err := s.indexer.GetByKey(s.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(v1alpha1.Resource("swarmpeer"), name)
+	}
+	return obj.(*v1alpha1.SwarmPeer), nil
+}